@@ -0,0 +1,188 @@
+// Package arena plays complete games between offline-mode bot executables on
+// the local machine, without a server.
+package arena
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/engine"
+	"github.com/jemoster/icfp2017/src/offline"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// LoadMap reads a JSON Map object from path.
+func LoadMap(path string) (*protocol.Map, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal map: %v", err)
+	}
+
+	return m, nil
+}
+
+// Config controls how a game is played.
+type Config struct {
+	Settings protocol.Settings
+
+	// SetupTimeout is the time allowed for each bot's setup.
+	SetupTimeout time.Duration
+
+	// MoveTimeout is the time allowed for each move.
+	MoveTimeout time.Duration
+
+	// MaxTimeouts is the number of failed moves after which a bot becomes
+	// a zombie. Zero disables zombification.
+	MaxTimeouts int
+}
+
+// Punter is one seat in the game.
+type Punter struct {
+	ID  uint64
+	Bot *offline.Bot
+
+	// State is the bot's state from its most recent successful stage.
+	State json.RawMessage
+
+	// Failures is the number of stages that timed out or failed.
+	Failures int
+
+	// Zombie punters pass for the rest of the game.
+	Zombie bool
+}
+
+// Game is a single game between bots.
+type Game struct {
+	// Name prefixes log messages, to tell concurrent games apart.
+	Name string
+
+	Map     *protocol.Map
+	Config  Config
+	State   *engine.GameState
+	Punters []*Punter
+}
+
+// New returns a game on m between the bots run by commands, seated in order.
+func New(m *protocol.Map, config Config, commands []string) *Game {
+	g := &Game{
+		Map:    m,
+		Config: config,
+		State:  engine.New(m, len(commands), config.Settings),
+	}
+
+	for i, c := range commands {
+		g.Punters = append(g.Punters, &Punter{
+			ID:  uint64(i),
+			Bot: offline.New(c),
+		})
+	}
+
+	return g
+}
+
+// Run plays the whole game and returns the final scores.
+func (g *Game) Run() []protocol.Score {
+	g.setup()
+	g.play()
+	scores := g.State.Scores()
+	g.stop(g.State.LastMoves(), scores)
+	return scores
+}
+
+func (g *Game) warningf(p *Punter, format string, args ...interface{}) {
+	glog.Warningf("%s[%d] %s", g.Name, p.ID, fmt.Sprintf(format, args...))
+}
+
+// fail records a failed stage for p.
+func (g *Game) fail(p *Punter, err error) {
+	p.Failures++
+	g.warningf(p, "%s failed (%d times so far): %v", p.Bot.Name, p.Failures, err)
+
+	if g.Config.MaxTimeouts > 0 && p.Failures >= g.Config.MaxTimeouts {
+		g.warningf(p, "%s is now a zombie", p.Bot.Name)
+		p.Zombie = true
+	}
+}
+
+func (g *Game) setup() {
+	for _, p := range g.Punters {
+		s := &protocol.Setup{
+			Punter:   p.ID,
+			Punters:  uint64(len(g.Punters)),
+			Map:      *g.Map,
+			Settings: g.State.Settings,
+		}
+
+		r, err := p.Bot.Setup(s, g.Config.SetupTimeout)
+		if err != nil {
+			// Without a state there is nothing to play with.
+			g.warningf(p, "setup failed, zombifying: %v", err)
+			p.Zombie = true
+			continue
+		}
+
+		if r.Ready != p.ID {
+			g.warningf(p, "%s is very confused about its identity (%d)", p.Bot.Name, r.Ready)
+		}
+
+		for _, err := range g.State.SetFutures(p.ID, r.Futures) {
+			g.warningf(p, "%v", err)
+		}
+
+		p.State = r.State
+		glog.Infof("%s[%d] %s is ready", g.Name, p.ID, p.Bot.Name)
+	}
+}
+
+// play runs the gameplay stages.
+func (g *Game) play() {
+	for !g.State.Done() {
+		p := g.Punters[g.State.Current()]
+
+		if p.Zombie {
+			g.State.Pass(p.ID)
+			continue
+		}
+
+		out, err := p.Bot.Play(g.State.LastMoves(), p.State, g.Config.MoveTimeout)
+		if err != nil {
+			g.fail(p, err)
+			g.State.Pass(p.ID)
+			continue
+		}
+		p.State = out.State
+
+		if err := g.State.ApplyMove(p.ID, out.Move); err != nil {
+			g.warningf(p, "%v: %v", err, out.Move)
+			g.State.Pass(p.ID)
+		}
+
+		glog.V(1).Infof("%sTurn %d: %v", g.Name, g.State.Turn, g.State.History[len(g.State.History)-1])
+	}
+}
+
+func (g *Game) stop(moves []protocol.Move, scores []protocol.Score) {
+	s := &protocol.Stop{
+		Moves:   moves,
+		Scores:  scores,
+		Futures: g.State.Futures(),
+	}
+
+	for _, p := range g.Punters {
+		if p.Zombie {
+			continue
+		}
+
+		if err := p.Bot.Stop(s, p.State, g.Config.MoveTimeout); err != nil {
+			g.warningf(p, "stop failed: %v", err)
+		}
+	}
+}
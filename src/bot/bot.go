@@ -0,0 +1,237 @@
+// Package bot does the work every offline-mode bot repeats, so that a bot
+// need only decide on its moves.
+//
+// The framework keeps a State for the bot, with the map as the server last
+// described it, and rebuilds a graph.Graph from it before each decision. The
+// moves of the other punters have already been applied to the graph, and
+// their splurge credit and options counted in the State's Ledger. Bots that
+// need more state embed State in their own state type, which the framework
+// marshals between stages.
+package bot
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/moves"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// State is the state the framework keeps for every bot.
+type State struct {
+	Punter   uint64
+	Punters  uint64
+	Map      protocol.Map
+	Settings protocol.Settings
+
+	// Turn is the number of moves we have made.
+	Turn uint64
+
+	Ledger *moves.Ledger
+}
+
+// Base returns s, so that types embedding State implement Stater.
+func (s *State) Base() *State {
+	return s
+}
+
+// Stater is a bot's state: a pointer to a type that embeds State, or to a
+// State itself.
+type Stater interface {
+	Base() *State
+}
+
+// Bot decides on moves. Each method is called in a new process, so
+// everything the bot needs to remember must be kept in its state.
+type Bot interface {
+	Name() string
+
+	// NewState returns a new, empty state, into which the framework
+	// fills or unmarshals the bot's state.
+	NewState() Stater
+
+	// Setup prepares s for the game, on g, the map as yet unclaimed. It
+	// returns the futures to bid, which are ignored unless enabled.
+	//
+	// Returning a non-nil error aborts the game.
+	Setup(s Stater, g *graph.Graph) ([]protocol.Future, error)
+
+	// Move returns the next move, given g with every move so far made.
+	//
+	// Returning a non-nil error aborts the game.
+	Move(s Stater, g *graph.Graph) (protocol.Move, error)
+}
+
+// Weighter is implemented by bots that want a graph weighted other than by
+// Weight.
+type Weighter interface {
+	Weight(s Stater) graph.WeightFunc
+}
+
+// Stopper is implemented by bots that want to see the final scores.
+type Stopper interface {
+	Stop(s Stater, stop *protocol.Stop) error
+}
+
+// Weight returns a WeightFunc for punter, under which rivers it holds are
+// free, unclaimed rivers have weight 1 and others can't be used.
+func Weight(punter uint64) graph.WeightFunc {
+	return func(e *graph.MetadataEdge) float64 {
+		if e.HeldBy(punter) {
+			return 0.0
+		}
+
+		if !e.IsOwned {
+			return 1.0
+		}
+
+		return math.Inf(0)
+	}
+}
+
+var compactState = flag.Bool("compact_state", false, "pass state between stages compactly")
+
+// start is when Run was called.
+var start time.Time
+
+// Started returns when the process started, or rather when Run was called,
+// from which time budgets should be counted.
+func Started() time.Time {
+	return start
+}
+
+// Run plays the current stage of the game with b, over stdin and stdout.
+// It parses the command line, so the bot's flags must already be defined.
+func Run(b Bot) {
+	start = time.Now()
+
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if err := protocol.Play(os.Stdin, os.Stdout, Game(b)); err != nil {
+		glog.Exitf("Play failed: %v", err)
+	}
+
+	glog.Infof("Run time: %v", time.Since(start))
+}
+
+// Game returns a protocol.Game that plays with b.
+//
+// States are decoded by protocol.CompactGame, and with -compact_state encoded
+// by it too.
+func Game(b Bot) protocol.Game {
+	return protocol.CompactGame(game{b}, *compactState)
+}
+
+type game struct {
+	b Bot
+}
+
+func (g game) Name() string {
+	return g.b.Name()
+}
+
+func (g game) weight(s Stater) graph.WeightFunc {
+	if w, ok := g.b.(Weighter); ok {
+		return w.Weight(s)
+	}
+	return Weight(s.Base().Punter)
+}
+
+func (g game) state(jsonState json.RawMessage) (Stater, error) {
+	s := g.b.NewState()
+	if err := json.Unmarshal([]byte(jsonState), s); err != nil {
+		return nil, fmt.Errorf("error unmarshaling state %s: %v", string(jsonState), err)
+	}
+	return s, nil
+}
+
+func (g game) Setup(setup *protocol.Setup) (*protocol.Ready, error) {
+	glog.Infof("Setup: game settings: %+v", setup.Settings)
+
+	s := g.b.NewState()
+	base := s.Base()
+	base.Punter = setup.Punter
+	base.Punters = setup.Punters
+	base.Map = setup.Map
+	base.Settings = setup.Settings
+	base.Ledger = moves.NewLedger(setup)
+
+	futures, err := g.b.Setup(s, graph.New(&base.Map, g.weight(s)))
+	if err != nil {
+		return nil, err
+	}
+	if !base.Settings.Futures {
+		futures = nil
+	}
+
+	return &protocol.Ready{
+		Ready:   base.Punter,
+		Futures: futures,
+		State:   s,
+	}, nil
+}
+
+func (g game) Play(m []protocol.Move, jsonState json.RawMessage) (*protocol.GameplayOutput, error) {
+	s, err := g.state(jsonState)
+	if err != nil {
+		return nil, err
+	}
+	base := s.Base()
+
+	glog.Infof("Turn: %d", base.Turn)
+
+	gr := graph.New(&base.Map, g.weight(s))
+	base.Ledger.Update(gr, m)
+	updateRivers(gr, base.Map.Rivers)
+
+	move, err := g.b.Move(s, gr)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Playing: %v", move)
+
+	base.Turn++
+
+	return &protocol.GameplayOutput{
+		Move:  move,
+		State: s,
+	}, nil
+}
+
+func (g game) Stop(stop *protocol.Stop, jsonState json.RawMessage) error {
+	glog.Infof("Stop: %+v", stop)
+
+	s, err := g.state(jsonState)
+	if err != nil {
+		return err
+	}
+
+	if st, ok := g.b.(Stopper); ok {
+		return st.Stop(s, stop)
+	}
+	return nil
+}
+
+// updateRivers copies the ownership of each river from g. Unlike
+// Graph.SerializeRivers, the rivers stay in their original order, so bots
+// may refer to them by index.
+func updateRivers(g *graph.Graph, rivers []protocol.River) {
+	for i := range rivers {
+		r := &rivers[i]
+		e := g.EdgeBetween(g.Node(int64(r.Source)), g.Node(int64(r.Target)))
+		if e == nil {
+			continue
+		}
+		edge := e.(*graph.MetadataEdge)
+
+		r.IsOwned, r.OwnerPunter = edge.IsOwned, edge.OwnerPunter
+		r.IsOptioned, r.OptionPunter = edge.IsOptioned, edge.OptionPunter
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/moves"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// futureRisk is the chance that a single opponent cuts our route at a river
+// with no way around it. Denser regions offer more detours, which divide the
+// risk.
+const futureRisk = 0.5
+
+// futureBudget is the fraction of our moves we are willing to plan to spend
+// completing futures.
+const futureBudget = 0.5
+
+// PlannedFuture is a future we bid on, and what has become of it.
+type PlannedFuture struct {
+	protocol.Future
+
+	// Distance is the number of rivers between the mine and the target,
+	// which the future is worth the cube of.
+	Distance uint64
+
+	// Odds is the estimated chance, at setup, of completing the future.
+	Odds float64
+
+	// Complete is set once we connect the mine to the target, and Lost
+	// once we no longer can in the moves we have left.
+	Complete bool
+	Lost     bool
+}
+
+func (f *PlannedFuture) open() bool {
+	return !f.Complete && !f.Lost
+}
+
+// FutureBids returns the futures to bid in Ready.
+func (s *state) FutureBids() []protocol.Future {
+	var bids []protocol.Future
+	for _, f := range s.Futures {
+		bids = append(bids, f.Future)
+	}
+	return bids
+}
+
+// remainingMoves estimates the number of moves we have left.
+func (s *state) remainingMoves() int64 {
+	return (int64(len(s.Map.Rivers)) - int64(s.Turn)) / int64(s.Punters)
+}
+
+// BidFutures bids a future on each mine whose expected value is positive, and
+// then steers claims toward completing them while they can be completed.
+//
+// A future on a site d rivers from its mine wins d³ if completed and loses as
+// much if not. The chance of completing it is estimated as the chance that no
+// opponent cuts the route at any of its d rivers, where the risk at each
+// river grows with the number of opponents and shrinks with the density of
+// rivers around the mine. The distance with the best expected value is bid,
+// as long as the routes to all the futures fit in our budget of moves.
+//
+// A BidFutures is used for a single turn, in which it checks the futures
+// once, however often it is asked.
+type BidFutures struct {
+	checked bool
+	future  *PlannedFuture
+	route   []protocol.SiteID
+}
+
+func (BidFutures) Name() string {
+	return "BidFutures"
+}
+
+// candidateFuture is the best future for one mine.
+type candidateFuture struct {
+	PlannedFuture
+	value float64
+}
+
+// byValue sorts candidates from the greatest expected value to the least.
+type byValue []candidateFuture
+
+func (c byValue) Len() int           { return len(c) }
+func (c byValue) Less(i, j int) bool { return c[i].value > c[j].value }
+func (c byValue) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+func (BidFutures) SetUp(s *state, g *graph.Graph) error {
+	if !s.Settings.Futures {
+		return nil
+	}
+
+	c := graph.NewCompact(&s.Map)
+	dist := c.MineDistances()
+	opponents := float64(s.Punters - 1)
+
+	var candidates []candidateFuture
+	for i, mine := range c.Mines {
+		if f, ok := bestFuture(c, mine, dist[i], opponents); ok {
+			candidates = append(candidates, f)
+		}
+	}
+	sort.Stable(byValue(candidates))
+
+	budget := uint64(futureBudget * float64(len(s.Map.Rivers)) / float64(s.Punters))
+	var planned uint64
+	for _, f := range candidates {
+		if planned+f.Distance > budget {
+			continue
+		}
+		planned += f.Distance
+
+		glog.Infof("Bidding future %d -> %d: distance %d, odds %.2f", f.Source, f.Target, f.Distance, f.Odds)
+		s.Futures = append(s.Futures, f.PlannedFuture)
+	}
+
+	return nil
+}
+
+// bestFuture returns the future on mine with the greatest positive expected
+// value, given the distances from it to each site.
+func bestFuture(c *graph.Compact, mine int32, dist []int32, opponents float64) (candidateFuture, bool) {
+	// Count the sites and rivers ends at each distance, to find the
+	// density of rivers within each distance.
+	var sites, degrees []int
+	for i, d := range dist {
+		if d == graph.Unreachable {
+			continue
+		}
+		for int(d) >= len(sites) {
+			sites = append(sites, 0)
+			degrees = append(degrees, 0)
+		}
+		sites[d]++
+		degrees[d] += c.Degree(int32(i))
+	}
+
+	var best candidateFuture
+	var n, ends int
+	for d := range sites {
+		n += sites[d]
+		ends += degrees[d]
+		if d == 0 {
+			continue
+		}
+
+		// Each site has one way on and, on average, density-1 others
+		// to detour through.
+		density := float64(ends) / float64(n)
+		risk := futureRisk * opponents / (opponents + 1) / math.Max(1, density-1)
+		odds := math.Pow(1-risk, float64(d))
+		cube := float64(d * d * d)
+		value := odds*cube - (1-odds)*cube
+
+		if value > best.value {
+			best = candidateFuture{
+				PlannedFuture: PlannedFuture{
+					Future:   protocol.Future{Source: c.Sites[mine]},
+					Distance: uint64(d),
+					Odds:     odds,
+				},
+				value: value,
+			}
+		}
+	}
+	if best.value <= 0 {
+		return best, false
+	}
+
+	// Of the sites at the best distance, the one with the most rivers is
+	// the hardest to cut off.
+	target := int32(-1)
+	for i, d := range dist {
+		if uint64(d) != best.Distance || d == graph.Unreachable || isMine(c, int32(i)) {
+			continue
+		}
+		if target == -1 || c.Degree(int32(i)) > c.Degree(target) {
+			target = int32(i)
+		}
+	}
+	if target == -1 {
+		return best, false
+	}
+	best.Target = c.Sites[target]
+
+	return best, true
+}
+
+func isMine(c *graph.Compact, site int32) bool {
+	for _, m := range c.Mines {
+		if m == site {
+			return true
+		}
+	}
+	return false
+}
+
+// check marks futures that have been completed, or can no longer be
+// completed in the moves we have left. It returns the open future closest to
+// completion, and the route to it, which may use options. The futures are
+// only checked the first time each turn.
+func (b *BidFutures) check(s *state, g *graph.Graph) (*PlannedFuture, []protocol.SiteID) {
+	if !b.checked {
+		b.future, b.route = closestFuture(s, g)
+		b.checked = true
+	}
+	return b.future, b.route
+}
+
+func closestFuture(s *state, g *graph.Graph) (*PlannedFuture, []protocol.SiteID) {
+	var closest *PlannedFuture
+	var route []protocol.SiteID
+	var cost float64
+
+	for i := range s.Futures {
+		f := &s.Futures[i]
+		if !f.open() {
+			continue
+		}
+
+		if g.Connected(s.Punter, f.Source, f.Target) {
+			glog.Infof("Completed future %d -> %d", f.Source, f.Target)
+			f.Complete = true
+			continue
+		}
+
+		r, c := moves.Route(g, s.Settings, s.Ledger, f.Source, f.Target)
+		if r == nil || c > float64(s.remainingMoves()) {
+			glog.Infof("Lost future %d -> %d", f.Source, f.Target)
+			f.Lost = true
+			continue
+		}
+
+		if closest == nil || c < cost {
+			closest, route, cost = f, r, c
+		}
+	}
+
+	return closest, route
+}
+
+func (b *BidFutures) IsApplicable(s *state, g *graph.Graph) bool {
+	f, _ := b.check(s, g)
+	return f != nil
+}
+
+func (b *BidFutures) Run(s *state, g *graph.Graph) (*protocol.GameplayOutput, error) {
+	f, route := b.check(s, g)
+	if f == nil {
+		return nil, nil
+	}
+
+	proposals := moves.Propose(g, s.Settings, s.Ledger, route)
+	if len(proposals) == 0 {
+		return nil, nil
+	}
+
+	glog.Infof("Working towards future %d -> %d", f.Source, f.Target)
+	return &protocol.GameplayOutput{
+		Move:  proposals[0],
+		State: s,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// line is a map of n sites in a line, with the given mines.
+func line(n int, mines ...protocol.SiteID) *protocol.Map {
+	m := &protocol.Map{Mines: mines}
+	for i := 0; i < n; i++ {
+		m.Sites = append(m.Sites, protocol.Site{ID: protocol.SiteID(i)})
+		if i > 0 {
+			m.Rivers = append(m.Rivers, protocol.River{Source: protocol.SiteID(i - 1), Target: protocol.SiteID(i)})
+		}
+	}
+	return m
+}
+
+func TestBestFuture(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		m         *protocol.Map
+		opponents float64
+
+		ok       bool
+		distance uint64
+	}{
+		// With one opponent, each river is cut with chance 1/4, so two
+		// rivers away is worth most: a third river has odds under a half.
+		{"one opponent", line(10, 0), 1, true, 2},
+		// Many opponents leave the odds of even two rivers under a half.
+		{"many opponents", line(10, 0), 15, true, 1},
+		// Nothing is certain to be completed but futures on the mine
+		// next door, which can't be bid.
+		{"only a mine near", line(2, 0, 1), 0, false, 0},
+	} {
+		c := graph.NewCompact(tt.m)
+		f, ok := bestFuture(c, c.Mines[0], c.MineDistances()[0], tt.opponents)
+		if ok != tt.ok {
+			t.Errorf("%s: bid %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && f.Distance != tt.distance {
+			t.Errorf("%s: bid on distance %d, want %d", tt.name, f.Distance, tt.distance)
+		}
+	}
+}
+
+func loadMap(t *testing.T, name string) *protocol.Map {
+	b, err := ioutil.ReadFile("../../../../maps/" + name)
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+// TestSetUpBids checks every bid made on a variety of maps: it must be on a
+// site that isn't a mine, at the distance claimed, with odds better than
+// even, and the bids together must fit in the move budget.
+func TestSetUpBids(t *testing.T) {
+	bids := 0
+	for _, name := range []string{"sample.json", "lambda.json", "circle.json", "randomMedium.json", "tube.json"} {
+		m := loadMap(t, name)
+		c := graph.NewCompact(m)
+		dist := c.MineDistances()
+
+		for punters := uint64(2); punters <= 8; punters *= 2 {
+			s := InitializeState(&protocol.Setup{Punter: 0, Punters: punters, Map: *m, Settings: protocol.Settings{Futures: true}})
+			if err := (&BidFutures{}).SetUp(s, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			bids += len(s.Futures)
+			var planned uint64
+			for _, f := range s.Futures {
+				planned += f.Distance
+				if isMine(c, c.Index[f.Target]) {
+					t.Errorf("%s, %d punters: bid on mine %d", name, punters, f.Target)
+				}
+				if f.Odds <= 0.5 {
+					t.Errorf("%s, %d punters: bid %d -> %d with odds %.2f", name, punters, f.Source, f.Target, f.Odds)
+				}
+				for i, mine := range c.Mines {
+					if c.Sites[mine] == f.Source && uint64(dist[i][c.Index[f.Target]]) != f.Distance {
+						t.Errorf("%s, %d punters: %d -> %d is %d rivers, not %d", name, punters, f.Source, f.Target, dist[i][c.Index[f.Target]], f.Distance)
+					}
+				}
+			}
+
+			budget := uint64(futureBudget * float64(len(m.Rivers)) / float64(punters))
+			if planned > budget {
+				t.Errorf("%s, %d punters: bids need %d moves, over the budget of %d", name, punters, planned, budget)
+			}
+		}
+	}
+	if bids == 0 {
+		t.Error("No futures bid on any map")
+	}
+}
+
+// TestSetUpBudget checks that bids beyond the budget are dropped: on a line
+// with a mine at each end, each mine's best future is two rivers away, but
+// two players only have moves for one.
+func TestSetUpBudget(t *testing.T) {
+	m := line(10, 0, 9)
+	s := InitializeState(&protocol.Setup{Punter: 0, Punters: 2, Map: *m, Settings: protocol.Settings{Futures: true}})
+	if err := (&BidFutures{}).SetUp(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Futures) != 1 {
+		t.Errorf("%d futures bid, want 1: %+v", len(s.Futures), s.Futures)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/moves"
 	"github.com/jemoster/icfp2017/src/protocol"
 )
 
@@ -26,17 +27,23 @@ type state struct {
 	Punter              uint64
 	Punters             uint64
 	Map                 protocol.Map
+	Settings            protocol.Settings
 
 	Turn uint64
+
+	Ledger *moves.Ledger
 }
 
 func InitializeState(setup *protocol.Setup) *state {
 	return &state{
-		Punter:  setup.Punter,
-		Punters: setup.Punters,
-		Map:     setup.Map,
+		Punter:   setup.Punter,
+		Punters:  setup.Punters,
+		Map:      setup.Map,
+		Settings: setup.Settings,
 
 		Turn: 0,
+
+		Ledger: moves.NewLedger(setup),
 	}
 }
 
@@ -89,8 +96,9 @@ func (Strategery) Setup(setup *protocol.Setup) (*protocol.Ready, error) {
 
 	glog.Infof("Setup complete with available mine rivers %+v", s.AvailableMineRivers)
 	return &protocol.Ready{
-		Ready: s.Punter,
-		State: s,
+		Ready:   s.Punter,
+		Futures: s.FutureBids(),
+		State:   s,
 	}, nil
 }
 
@@ -103,7 +111,7 @@ func (Strategery) Play(m []protocol.Move, jsonState json.RawMessage) (*protocol.
 	}
 
 	g := graph.New(&s.Map, s.weightFunc())
-	g.Update(m)
+	s.Ledger.Update(g, m)
 	s.Update(g, m)
 	glog.Infof("Turn: %d", s.Turn)
 
@@ -17,17 +17,18 @@ type Strategy interface {
 }
 
 func AllStrategies(s *state, g *graph.Graph) []Strategy {
-	return []Strategy{CaptureMineAdjacentRivers{}, ConnectRivers{}, RandomWalkPaths{}}
+	return []Strategy{&BidFutures{}, CaptureMineAdjacentRivers{}, ConnectRivers{}, RandomWalkPaths{}}
 }
 
 func DetermineStrategies(s *state, g *graph.Graph) []Strategy {
-	return []Strategy{CaptureMineAdjacentRivers{}, ConnectRivers{}, RandomWalkPaths{}}
+	return []Strategy{&BidFutures{}, CaptureMineAdjacentRivers{}, ConnectRivers{}, RandomWalkPaths{}}
 }
 
 type StrategyStateRegistry struct {
 	ActivePaths         [][]protocol.Site
 	UnconnectedOrigins  []protocol.River
 	AvailableMineRivers []protocol.River
+	Futures             []PlannedFuture
 }
 
 type CaptureMineAdjacentRivers struct {}
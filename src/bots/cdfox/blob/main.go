@@ -1,31 +1,20 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
 	"fmt"
 	"math/rand"
-	"os"
 
 	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/bot"
 	"github.com/jemoster/icfp2017/src/graph"
 	"github.com/jemoster/icfp2017/src/protocol"
 	gonumGraph "gonum.org/v1/gonum/graph"
 )
 
 type state struct {
-	Punter  uint64
-	Punters uint64
-	Map     protocol.Map
+	bot.State
 
 	RiversToClaim []int
-
-	Turn uint64
-}
-
-func (s *state) Update(g *graph.Graph, m []protocol.Move) {
-	s.Map.Rivers = g.SerializeRivers()
-	s.Turn += uint64(len(m))
 }
 
 // string representation of river where smaller id comes first
@@ -47,23 +36,22 @@ func (Blob) Name() string {
 	return "blob"
 }
 
-func (Blob) Setup(setup *protocol.Setup) (*protocol.Ready, error) {
-	glog.Infof("Setup")
+func (Blob) NewState() bot.Stater {
+	return &state{}
+}
 
-	s := state{
-		Punter:  setup.Punter,
-		Punters: setup.Punters,
-		Map:     setup.Map,
-		Turn:    0,
-	}
+func (Blob) Weight(bot.Stater) graph.WeightFunc {
+	return weight
+}
 
-	// Pick a random mine.
-	blobCenter := protocol.SiteID(rand.Intn(len(setup.Map.Mines)))
+func (Blob) Setup(st bot.Stater, g *graph.Graph) ([]protocol.Future, error) {
+	s := st.(*state)
 
-	g := graph.New(&setup.Map, weight)
+	// Pick a random mine.
+	blobCenter := protocol.SiteID(rand.Intn(len(s.Map.Mines)))
 
 	riverIdx := map[string]int{}
-	for i, r := range setup.Map.Rivers {
+	for i, r := range s.Map.Rivers {
 		riverIdx[riverKey(r)] = i
 	}
 
@@ -92,23 +80,11 @@ func (Blob) Setup(setup *protocol.Setup) (*protocol.Ready, error) {
 
 	glog.Infof("%d rivers starting from blobCenter %d: %v", len(s.RiversToClaim), blobCenter, s.RiversToClaim)
 
-	return &protocol.Ready{
-		Ready: s.Punter,
-		State: s,
-	}, nil
+	return nil, nil
 }
 
-func (Blob) Play(m []protocol.Move, jsonState json.RawMessage) (*protocol.GameplayOutput, error) {
-	glog.Infof("Play")
-
-	var s state
-	if err := json.Unmarshal([]byte(jsonState), &s); err != nil {
-		return nil, fmt.Errorf("error unmarshaling state %s: %v", string(jsonState), err)
-	}
-
-	g := graph.New(&s.Map, weight)
-	g.Update(m)
-	s.Update(g, m)
+func (Blob) Move(st bot.Stater, g *graph.Graph) (protocol.Move, error) {
+	s := st.(*state)
 
 	// Pick an unclaimed river, or pass if all are claimed.
 	move := protocol.Move{}
@@ -138,31 +114,9 @@ func (Blob) Play(m []protocol.Move, jsonState json.RawMessage) (*protocol.Gamepl
 		}
 	}
 
-	glog.Infof("Turn: %d", s.Turn)
-
-	return &protocol.GameplayOutput{
-		Move:  move,
-		State: s,
-	}, nil
-}
-
-func (Blob) Stop(stop *protocol.Stop, jsonState json.RawMessage) error {
-	glog.Infof("Stop: %+v", stop)
-
-	var s state
-	if err := json.Unmarshal([]byte(jsonState), &s); err != nil {
-		return fmt.Errorf("error unmarshaling state %s: %v", string(jsonState), err)
-	}
-
-	return nil
+	return move, nil
 }
 
 func main() {
-	flag.Set("logtostderr", "true")
-	flag.Parse()
-
-	var s Blob
-	if err := protocol.Play(os.Stdin, os.Stdout, &s); err != nil {
-		glog.Exitf("Play failed: %v", err)
-	}
+	bot.Run(Blob{})
 }
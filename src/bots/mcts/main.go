@@ -0,0 +1,60 @@
+// Command mcts is a punter that picks each move by Monte Carlo Tree Search.
+//
+// Every move, it grows a tree of claims, options, splurges and passes for
+// every punter in turn, estimating each with short playouts of random claims
+// that favour rivers extending the claimant's network. Playouts are scored
+// with the graph package's running scores, and the search stops in time to
+// answer within the move budget.
+//
+// Two simplifications keep the search narrow and quick:
+//
+//   - Splurges are only searched as one claim that extends a network plus
+//     one more free river beyond it, never longer routes or routes through
+//     others' rivers.
+//   - Playouts are scored on mines and sites alone. Futures are ignored, as
+//     the bot bids none, and other punters' futures aren't known.
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/bot"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+var (
+	budget = flag.Duration("budget", 800*time.Millisecond, "time to spend on each move, counted from process start")
+	depth  = flag.Int("depth", 64, "maximum number of moves in each playout")
+	width  = flag.Int("width", 24, "maximum number of claims to consider from each position")
+	seed   = flag.Int64("seed", 0, "random seed (0 to seed from the clock)")
+)
+
+// state is the state of the search between moves, of which there is none
+// beyond what the framework keeps.
+type state struct {
+	bot.State
+}
+
+type MCTS struct{}
+
+func (MCTS) Name() string {
+	return "mcts"
+}
+
+func (MCTS) NewState() bot.Stater {
+	return &state{}
+}
+
+func (MCTS) Setup(s bot.Stater, g *graph.Graph) ([]protocol.Future, error) {
+	return nil, nil
+}
+
+func (MCTS) Move(s bot.Stater, g *graph.Graph) (protocol.Move, error) {
+	return newSearch(g, s.(*state)).run(bot.Started().Add(*budget)), nil
+}
+
+func main() {
+	bot.Run(MCTS{})
+}
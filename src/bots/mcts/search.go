@@ -0,0 +1,426 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// exploration weighs trying rarely visited moves against exploiting the best
+// ones, in the UCT formula.
+const exploration = 0.5
+
+// tries is the number of rivers a playout samples, looking for one that
+// extends the claimant's network, before settling for any free river.
+const tries = 8
+
+// node is a position in the search tree, reached by making move.
+type node struct {
+	move   protocol.Move
+	punter uint64
+	parent *node
+
+	children []*node
+
+	// untried are the moves not yet expanded into children, best first.
+	// They are only generated once the node has been visited.
+	untried  []protocol.Move
+	expanded bool
+
+	visits float64
+
+	// reward is the total share of the score won by punter in playouts
+	// through this node.
+	reward float64
+}
+
+// search grows a tree of moves from the current position of g, using Apply
+// and Undo to move between positions.
+type search struct {
+	g   *graph.Graph
+	s   *state
+	rng *rand.Rand
+
+	rivers []*graph.MetadataEdge
+
+	// credit and options are copies of the ledger's, changed by the moves
+	// being searched.
+	credit  []int
+	options []int
+
+	// turn is the number of moves made before the search, and turns the
+	// number of moves in the game.
+	turn  int
+	turns int
+
+	// moves are the moves applied to g since the start of the search.
+	moves []protocol.Move
+
+	// free and scores are reused by each playout.
+	free   []*graph.MetadataEdge
+	scores []float64
+}
+
+func newSearch(g *graph.Graph, s *state) *search {
+	sd := *seed
+	if sd == 0 {
+		sd = time.Now().UnixNano()
+	}
+
+	t := &search{
+		g:       g,
+		s:       s,
+		rng:     rand.New(rand.NewSource(sd)),
+		credit:  append([]int(nil), s.Ledger.Credit...),
+		options: append([]int(nil), s.Ledger.Options...),
+		turn:    int(s.Punter + s.Turn*s.Punters),
+		turns:   len(s.Map.Rivers),
+		scores:  make([]float64, s.Punters),
+	}
+
+	for _, e := range g.Edges() {
+		t.rivers = append(t.rivers, e.(*graph.MetadataEdge))
+	}
+
+	c := graph.NewCompact(&s.Map)
+	dist := c.MineDistances()
+	rows := make(map[protocol.SiteID][]int32, len(c.Mines))
+	for i, mine := range c.Mines {
+		rows[c.Sites[mine]] = dist[i]
+	}
+	g.TrackScore(s.Map.Mines, func(p uint64, mine, site protocol.SiteID) int64 {
+		d := int64(rows[mine][c.Index[site]])
+		if d == graph.Unreachable {
+			return 0
+		}
+		return d * d
+	})
+
+	return t
+}
+
+// run searches until deadline, and returns the most visited move. At least
+// one playout is made, however late it is, and no more if there is only one
+// move to make.
+func (t *search) run(deadline time.Time) protocol.Move {
+	root := &node{punter: t.s.Punter}
+
+	for root.visits == 0 || time.Now().Before(deadline) {
+		t.iterate(root)
+		if len(root.children)+len(root.untried) < 2 {
+			break
+		}
+	}
+
+	var best *node
+	for _, c := range root.children {
+		glog.V(1).Infof("%v: %.0f visits, mean share %.3f", c.move, c.visits, c.reward/c.visits)
+		if best == nil || c.visits > best.visits {
+			best = c
+		}
+	}
+	if best == nil {
+		return protocol.Move{Pass: &protocol.Pass{Punter: t.s.Punter}}
+	}
+
+	glog.Infof("Made %.0f playouts; best move %v has mean share %.3f", root.visits, best.move, best.reward/best.visits)
+	return best.move
+}
+
+// iterate makes one playout from a new leaf of the tree below root, and adds
+// its result to every node on the way.
+func (t *search) iterate(root *node) {
+	n := root
+	for n.expanded && len(n.untried) == 0 && len(n.children) > 0 {
+		n = t.choose(n)
+		t.play(n.move)
+	}
+
+	if !n.expanded && !t.over() {
+		n.untried = t.candidates(t.mover())
+		n.expanded = true
+	}
+	if len(n.untried) > 0 {
+		child := &node{
+			move:   n.untried[0],
+			punter: t.mover(),
+			parent: n,
+		}
+		n.untried = n.untried[1:]
+		n.children = append(n.children, child)
+
+		t.play(child.move)
+		n = child
+	}
+
+	shares := t.playout()
+
+	for len(t.moves) > 0 {
+		t.undo()
+	}
+
+	for ; n != nil; n = n.parent {
+		n.visits++
+		n.reward += shares[n.punter]
+	}
+}
+
+// choose returns the child of n with the greatest upper confidence bound on
+// its reward, for the punter to move at n.
+func (t *search) choose(n *node) *node {
+	var best *node
+	var bestValue float64
+	logVisits := math.Log(n.visits)
+	for _, c := range n.children {
+		value := c.reward/c.visits + exploration*math.Sqrt(logVisits/c.visits)
+		if best == nil || value > bestValue {
+			best, bestValue = c, value
+		}
+	}
+	return best
+}
+
+// over reports whether every move of the game has been made.
+func (t *search) over() bool {
+	return t.turn+len(t.moves) >= t.turns
+}
+
+// mover returns the punter whose turn it is.
+func (t *search) mover() uint64 {
+	return uint64(t.turn+len(t.moves)) % t.s.Punters
+}
+
+func (t *search) play(move protocol.Move) {
+	t.account(move, 1)
+	t.g.Apply(move)
+	t.moves = append(t.moves, move)
+}
+
+func (t *search) undo() {
+	move := t.moves[len(t.moves)-1]
+	t.moves = t.moves[:len(t.moves)-1]
+	t.g.Undo()
+	t.account(move, -1)
+}
+
+// account spends (or, with sign -1, refunds) the credit and options used by
+// move. The splurges searched never need options.
+func (t *search) account(move protocol.Move, sign int) {
+	switch {
+	case move.Pass != nil:
+		t.credit[move.Pass.Punter] += sign
+	case move.Option != nil:
+		t.options[move.Option.Punter] -= sign
+	case move.Splurge != nil:
+		t.credit[move.Splurge.Punter] -= sign * (len(move.Splurge.Route) - 2)
+	}
+}
+
+// gain returns how much move would immediately add to the score of the punter
+// making it.
+func (t *search) gain(p uint64, move protocol.Move) int64 {
+	before := t.g.RunningScore(p)
+	t.g.Apply(move)
+	after := t.g.RunningScore(p)
+	t.g.Undo()
+	return after - before
+}
+
+// scoredMove is a candidate move and its immediate gain.
+type scoredMove struct {
+	move protocol.Move
+	gain int64
+
+	// bridge is true for claims of rivers that are bridges of the
+	// claimant's available network: chokepoints that, claimed by anyone
+	// else, would cut it off from the sites beyond.
+	bridge bool
+}
+
+// byGain sorts moves from the greatest gain to the least, and of those with
+// equal gain, bridges first.
+type byGain []scoredMove
+
+func (s byGain) Len() int      { return len(s) }
+func (s byGain) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byGain) Less(i, j int) bool {
+	if s[i].gain != s[j].gain {
+		return s[i].gain > s[j].gain
+	}
+	return s[i].bridge && !s[j].bridge
+}
+
+func ends(r *graph.MetadataEdge) (protocol.SiteID, protocol.SiteID) {
+	return protocol.SiteID(r.From().ID()), protocol.SiteID(r.To().ID())
+}
+
+// candidates returns the moves worth searching for punter p, best first.
+//
+// These are claims that extend one of p's networks, options on such rivers
+// if p has any left, and, if p has credit, splurges of two rivers that reach
+// past the best claims. If p cannot extend its networks, any free river may
+// be claimed, to get in someone else's way. Of claims that gain as much, those
+// of bridges, which p could be cut off by, come first.
+func (t *search) candidates(p uint64) []protocol.Move {
+	g := t.g
+	canOption := t.s.Settings.Options && t.options[p] > 0
+	crit := g.Critical(p)
+
+	var claims, options, splurges []scoredMove
+	var free []*graph.MetadataEdge
+	for _, r := range t.rivers {
+		if r.IsOwned && !canOption {
+			continue
+		}
+		if !r.IsOwned {
+			free = append(free, r)
+		}
+
+		a, b := ends(r)
+		ra, rb := g.ReachesMine(p, a), g.ReachesMine(p, b)
+		if !ra && !rb || ra && rb && g.Connected(p, a, b) {
+			continue
+		}
+
+		switch {
+		case !r.IsOwned:
+			claims = append(claims, scoredMove{
+				move:   protocol.Move{Claim: &protocol.Claim{Punter: p, Source: a, Target: b}},
+				bridge: crit.IsBridge(r),
+			})
+		case r.OwnerPunter != p && !r.IsOptioned:
+			options = append(options, scoredMove{move: protocol.Move{Option: &protocol.Option{Punter: p, Source: a, Target: b}}})
+		}
+	}
+
+	if len(claims) == 0 {
+		for _, r := range free {
+			a, b := ends(r)
+			claims = append(claims, scoredMove{move: protocol.Move{Claim: &protocol.Claim{Punter: p, Source: a, Target: b}}})
+		}
+	}
+
+	claims = t.best(p, claims, *width)
+	options = t.best(p, options, (*width+3)/4)
+
+	if t.s.Settings.Splurges && t.credit[p] > 0 {
+		for i := 0; i < len(claims) && i < (*width+3)/4; i++ {
+			if s, ok := t.extend(p, claims[i].move.Claim); ok {
+				splurges = append(splurges, s)
+			}
+		}
+	}
+
+	all := append(append(claims, options...), splurges...)
+	sort.Stable(byGain(all))
+
+	moves := make([]protocol.Move, 0, len(all)+1)
+	for _, m := range all {
+		moves = append(moves, m.move)
+	}
+	if t.s.Settings.Splurges {
+		moves = append(moves, protocol.Move{Pass: &protocol.Pass{Punter: p}})
+	}
+	return moves
+}
+
+// best scores moves and returns the n with the greatest gain. Ties are broken
+// at random.
+func (t *search) best(p uint64, moves []scoredMove, n int) []scoredMove {
+	for i := len(moves) - 1; i > 0; i-- {
+		j := t.rng.Intn(i + 1)
+		moves[i], moves[j] = moves[j], moves[i]
+	}
+	for i := range moves {
+		moves[i].gain = t.gain(p, moves[i].move)
+	}
+	sort.Stable(byGain(moves))
+
+	if len(moves) > n {
+		moves = moves[:n]
+	}
+	return moves
+}
+
+// extend returns the best splurge that claims c and then one more free river
+// from the site c reaches, if there is one.
+func (t *search) extend(p uint64, c *protocol.Claim) (scoredMove, bool) {
+	g := t.g
+	from, to := c.Source, c.Target
+	if g.ReachesMine(p, to) {
+		from, to = to, from
+	}
+
+	var best scoredMove
+	found := false
+	for _, n := range g.From(g.Node(int64(to))) {
+		next := protocol.SiteID(n.ID())
+		if next == from || g.ReachesMine(p, next) {
+			continue
+		}
+		if r := g.EdgeBetween(g.Node(int64(to)), n).(*graph.MetadataEdge); r.IsOwned {
+			continue
+		}
+
+		s := scoredMove{move: protocol.Move{Splurge: &protocol.Splurge{
+			Punter: p,
+			Route:  []protocol.SiteID{from, to, next},
+		}}}
+		s.gain = t.gain(p, s.move)
+		if !found || s.gain > best.gain {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// playout claims random rivers for each punter in turn until the game ends
+// or the depth limit is reached, and returns each punter's share of the
+// total score. Each claim is likelier to extend the claimant's networks than
+// not.
+func (t *search) playout() []float64 {
+	t.free = t.free[:0]
+	for _, r := range t.rivers {
+		if !r.IsOwned {
+			t.free = append(t.free, r)
+		}
+	}
+
+	for i := 0; i < *depth && !t.over() && len(t.free) > 0; i++ {
+		p := t.mover()
+
+		var k int
+		for j := 0; j < tries; j++ {
+			k = t.rng.Intn(len(t.free))
+			a, b := ends(t.free[k])
+			if t.g.ReachesMine(p, a) || t.g.ReachesMine(p, b) {
+				break
+			}
+		}
+
+		a, b := ends(t.free[k])
+		t.free[k] = t.free[len(t.free)-1]
+		t.free = t.free[:len(t.free)-1]
+
+		t.play(protocol.Move{Claim: &protocol.Claim{Punter: p, Source: a, Target: b}})
+	}
+
+	var total float64
+	for p := range t.scores {
+		t.scores[p] = float64(t.g.RunningScore(uint64(p)))
+		total += t.scores[p]
+	}
+	for p := range t.scores {
+		if total > 0 {
+			t.scores[p] /= total
+		} else {
+			t.scores[p] = 1 / float64(len(t.scores))
+		}
+	}
+	return t.scores
+}
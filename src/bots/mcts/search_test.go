@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/bot"
+	"github.com/jemoster/icfp2017/src/engine"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/moves"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+func loadMap(t *testing.T, name string) *protocol.Map {
+	b, err := ioutil.ReadFile("../../../maps/" + name)
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+// position returns the state and graph a bot would be given as punter p in
+// game e.
+func position(e *engine.GameState, m *protocol.Map, p uint64, turn int) (*state, *graph.Graph) {
+	setup := &protocol.Setup{Punter: p, Punters: uint64(e.NumPunters), Map: *m, Settings: e.Settings}
+	s := &state{bot.State{
+		Punter:   p,
+		Punters:  uint64(e.NumPunters),
+		Map:      *m,
+		Settings: e.Settings,
+		Turn:     uint64(turn / e.NumPunters),
+		Ledger:   moves.NewLedger(setup),
+	}}
+	s.Map.Rivers = e.Graph.SerializeRivers()
+	for q := range s.Ledger.Credit {
+		s.Ledger.Credit[q] = e.Credit(uint64(q))
+		s.Ledger.Options[q] = e.Options(uint64(q))
+	}
+	return s, graph.New(&s.Map, bot.Weight(p))
+}
+
+// riverSet returns the rivers of g, whose order is not fixed.
+func riverSet(g *graph.Graph) map[protocol.River]bool {
+	set := make(map[protocol.River]bool)
+	for _, r := range g.SerializeRivers() {
+		set[r] = true
+	}
+	return set
+}
+
+// TestRun plays mcts against itself on a small map, checking that every move
+// it picks is legal, and that the search leaves the graph, credit and options
+// as it found them.
+func TestRun(t *testing.T) {
+	*seed = 1
+	settings := protocol.Settings{Options: true, Splurges: true}
+
+	m := loadMap(t, "lambda.json")
+	const punters = 2
+	e := engine.New(m, punters, settings)
+
+	for turn := 0; !e.Done(); turn++ {
+		p := e.Current()
+		s, g := position(e, m, p, turn)
+
+		search := newSearch(g, s)
+		scores := make([]int64, punters)
+		for q := range scores {
+			scores[q] = g.RunningScore(uint64(q))
+		}
+		rivers := riverSet(g)
+
+		move := search.run(time.Now().Add(5 * time.Millisecond))
+		if err := e.ApplyMove(p, move); err != nil {
+			t.Fatalf("Turn %d: illegal move %v: %v", turn, move, err)
+		}
+
+		if len(search.moves) != 0 {
+			t.Errorf("Turn %d: %d moves left applied", turn, len(search.moves))
+		}
+		if !reflect.DeepEqual(search.credit, s.Ledger.Credit) || !reflect.DeepEqual(search.options, s.Ledger.Options) {
+			t.Errorf("Turn %d: credit %v and options %v, want %v and %v", turn, search.credit, search.options, s.Ledger.Credit, s.Ledger.Options)
+		}
+		for q := range scores {
+			if got := g.RunningScore(uint64(q)); got != scores[q] {
+				t.Errorf("Turn %d: punter %d's score is %d after the search, not %d", turn, q, got, scores[q])
+			}
+		}
+		if !reflect.DeepEqual(riverSet(g), rivers) {
+			t.Errorf("Turn %d: the search changed the rivers", turn)
+		}
+	}
+}
+
+// TestAccount checks that undoing each kind of move refunds what playing it
+// spent.
+func TestAccount(t *testing.T) {
+	*seed = 1
+	settings := protocol.Settings{Options: true, Splurges: true}
+
+	m := loadMap(t, "sample.json")
+	e := engine.New(m, 2, settings)
+	for _, move := range []protocol.Move{
+		{Claim: &protocol.Claim{Punter: 0, Source: 0, Target: 1}},
+		{Pass: &protocol.Pass{Punter: 1}},
+		{Pass: &protocol.Pass{Punter: 0}},
+		{Pass: &protocol.Pass{Punter: 1}},
+	} {
+		if err := e.ApplyMove(e.Current(), move); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, g := position(e, m, 0, 4)
+	search := newSearch(g, s)
+	credit := append([]int(nil), search.credit...)
+	options := append([]int(nil), search.options...)
+
+	for _, move := range []protocol.Move{
+		{Pass: &protocol.Pass{Punter: 0}},
+		{Option: &protocol.Option{Punter: 1, Source: 0, Target: 1}},
+		{Splurge: &protocol.Splurge{Punter: 0, Route: []protocol.SiteID{1, 2, 3, 4}}},
+		{Claim: &protocol.Claim{Punter: 1, Source: 5, Target: 6}},
+	} {
+		search.play(move)
+	}
+	if reflect.DeepEqual(search.credit, credit) || reflect.DeepEqual(search.options, options) {
+		t.Fatalf("Credit %v and options %v unchanged by the moves", search.credit, search.options)
+	}
+
+	for len(search.moves) > 0 {
+		search.undo()
+	}
+	if !reflect.DeepEqual(search.credit, credit) || !reflect.DeepEqual(search.options, options) {
+		t.Errorf("Credit %v and options %v after undo, want %v and %v", search.credit, search.options, credit, options)
+	}
+}
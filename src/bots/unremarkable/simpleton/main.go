@@ -1,84 +1,33 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
-	"fmt"
-	"os"
-
-	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/bot"
+	"github.com/jemoster/icfp2017/src/graph"
 	"github.com/jemoster/icfp2017/src/protocol"
 )
 
-type state struct {
-	Punter  uint64
-	Punters uint64
-	Map     protocol.Map
-
-	Turn uint64
-}
-
 type Simpleton struct{}
 
 func (Simpleton) Name() string {
 	return "Simpleton"
 }
 
-func (Simpleton) Setup(setup *protocol.Setup) (*protocol.Ready, error) {
-	glog.Infof("Setup")
-
-	s := state{
-		Punter:  setup.Punter,
-		Punters: setup.Punters,
-		Map:     setup.Map,
-
-		Turn: 0,
-	}
-
-	return &protocol.Ready{
-		Ready: s.Punter,
-		State: s,
-	}, nil
+func (Simpleton) NewState() bot.Stater {
+	return &bot.State{}
 }
 
-func (Simpleton) Play(m []protocol.Move, jsonState json.RawMessage) (*protocol.GameplayOutput, error) {
-	glog.Infof("Play")
-
-	var s state
-	if err := json.Unmarshal([]byte(jsonState), &s); err != nil {
-		return nil, fmt.Errorf("error unmarshaling state %s: %v", string(jsonState), err)
-	}
-
-	s.Turn++
-	glog.Infof("Turn: %d", s.Turn)
+func (Simpleton) Setup(s bot.Stater, g *graph.Graph) ([]protocol.Future, error) {
+	return nil, nil
+}
 
-	return &protocol.GameplayOutput{
-		Move: protocol.Move{
-			Pass: &protocol.Pass{
-				s.Punter,
-			},
+func (Simpleton) Move(s bot.Stater, g *graph.Graph) (protocol.Move, error) {
+	return protocol.Move{
+		Pass: &protocol.Pass{
+			Punter: s.Base().Punter,
 		},
-		State: s,
 	}, nil
 }
 
-func (Simpleton) Stop(stop *protocol.Stop, jsonState json.RawMessage) error {
-	glog.Infof("Stop: %+v", stop)
-
-	var s state
-	if err := json.Unmarshal([]byte(jsonState), &s); err != nil {
-		return fmt.Errorf("error unmarshaling state %s: %v", string(jsonState), err)
-	}
-
-	return nil
-}
-
 func main() {
-	flag.Set("logtostderr", "true")
-	flag.Parse()
-
-	var s Simpleton
-	if err := protocol.Play(os.Stdin, os.Stdout, &s); err != nil {
-		glog.Exitf("Play failed: %v", err)
-	}
+	bot.Run(Simpleton{})
 }
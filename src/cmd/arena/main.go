@@ -0,0 +1,60 @@
+// Command arena plays a complete game between offline-mode bot executables
+// on the local machine, without a server.
+//
+// Usage:
+//
+//	arena -map maps/sample.json ./simpleton ./walk "python3 src/pybots/ai_random.py"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/arena"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+var (
+	mapPath      = flag.String("map", "maps/sample.json", "A file containing a JSON Map object")
+	setupTimeout = flag.Duration("setuptimeout", 10*time.Second, "time allowed for each bot's setup")
+	moveTimeout  = flag.Duration("movetimeout", time.Second, "time allowed for each move")
+	maxTimeouts  = flag.Int("maxtimeouts", 10, "number of failed moves after which a bot becomes a zombie (0 to disable)")
+
+	futures  = flag.Bool("futures", true, "to disable futures use --futures=false")
+	splurges = flag.Bool("splurges", true, "to disable splurges use --splurges=false")
+	options  = flag.Bool("options", true, "to disable options use --options=false")
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatal("usage: arena [flags] bot-command...")
+	}
+
+	m, err := arena.LoadMap(*mapPath)
+	if err != nil {
+		log.Fatalf("Failed to load map: %v", err)
+	}
+
+	config := arena.Config{
+		Settings: protocol.Settings{
+			Futures:  *futures,
+			Splurges: *splurges,
+			Options:  *options,
+		},
+		SetupTimeout: *setupTimeout,
+		MoveTimeout:  *moveTimeout,
+		MaxTimeouts:  *maxTimeouts,
+	}
+
+	g := arena.New(m, config, flag.Args())
+	scores := g.Run()
+
+	for _, s := range scores {
+		fmt.Printf("punter %d (%s): %d\n", s.Punter, g.Punters[s.Punter].Bot.Name, s.Score)
+	}
+}
@@ -0,0 +1,102 @@
+// Command online connects an offline-mode bot executable to an online-mode
+// server, like tools/bot_runner/online_adapter.py.
+//
+// Usage:
+//
+//	online [flags] exe port
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/offline"
+	"github.com/jemoster/icfp2017/src/online"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+var (
+	server       = flag.String("server", online.DefaultServer, "The game server to connect to")
+	record       = flag.String("record", "", "filename to save playlog to (.2.txt is appended); defaults to data/online/<time>")
+	noRecord     = flag.Bool("norecord", false, "don't save a playlog")
+	header       = flag.String("header", "", "extra metadata to save in the playlog")
+	setupTimeout = flag.Duration("setuptimeout", 10*time.Second, "time allowed for the bot's setup")
+	moveTimeout  = flag.Duration("movetimeout", time.Second, "time allowed for each of the bot's moves")
+)
+
+// byScore sorts scores from highest to lowest.
+type byScore []protocol.Score
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Less(i, j int) bool { return s[i].Score > s[j].Score }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		log.Fatal("usage: online [flags] exe port")
+	}
+
+	port, err := strconv.Atoi(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Bad port %q: %v", flag.Arg(1), err)
+	}
+
+	a := &online.Adapter{
+		Bot:          offline.New(flag.Arg(0)),
+		SetupTimeout: *setupTimeout,
+		MoveTimeout:  *moveTimeout,
+	}
+
+	if !*noRecord {
+		if *record == "" {
+			*record = filepath.Join("data", "online", strconv.FormatInt(time.Now().Unix(), 10))
+		}
+
+		name := *record + ".2.txt"
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			log.Fatalf("Failed to create playlog directory: %v", err)
+		}
+
+		f, err := os.Create(name)
+		if err != nil {
+			log.Fatalf("Failed to create playlog: %v", err)
+		}
+		defer f.Close()
+
+		md := online.NewMetadata(*server, port)
+		if *header != "" {
+			md.Extra = *header
+		}
+		b, err := json.Marshal(md)
+		if err != nil {
+			log.Fatalf("Failed to marshal metadata: %v", err)
+		}
+		fmt.Fprintf(f, "%s\n", b)
+
+		a.Record = f
+	}
+
+	scores, err := a.Dial(fmt.Sprintf("%s:%d", *server, port))
+	if err != nil {
+		log.Fatalf("Game failed: %v", err)
+	}
+
+	sort.Stable(byScore(scores))
+	for _, s := range scores {
+		who := "punter:"
+		if s.Punter == a.Punter {
+			who = "me:    "
+		}
+		fmt.Printf("%s %d, score: %d\n", who, s.Punter, s.Score)
+	}
+}
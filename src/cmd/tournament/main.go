@@ -0,0 +1,356 @@
+// Command tournament plays many games between offline-mode bot executables
+// on the local machine and rates the bots.
+//
+// Ratings are kept per map size class in a JSON file and carried over
+// between tournaments, so new bots can be compared against old ones without
+// replaying every game.
+//
+// Usage:
+//
+//	tournament -maps maps -class small,medium ./simpleton ./walk "python3 src/pybots/ai_random.py"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/arena"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+var (
+	mapDir      = flag.String("maps", "maps", "directory of JSON Map objects to play on")
+	classes     = flag.String("class", "", "comma separated map size classes to play (small, medium, large); empty for all")
+	format      = flag.String("format", "roundrobin", "tournament format: roundrobin or swiss")
+	rounds      = flag.Int("rounds", 5, "number of rounds in a swiss tournament")
+	seats       = flag.Int("seats", 2, "number of punters in each game")
+	parallel    = flag.Int("parallel", runtime.NumCPU(), "number of games to play at once")
+	ratingsPath = flag.String("ratings", "data/tournament/ratings.json", "file in which ratings are kept between tournaments")
+
+	setupTimeout = flag.Duration("setuptimeout", 10*time.Second, "time allowed for each bot's setup")
+	moveTimeout  = flag.Duration("movetimeout", time.Second, "time allowed for each move")
+	maxTimeouts  = flag.Int("maxtimeouts", 10, "number of failed moves after which a bot becomes a zombie (0 to disable)")
+
+	futures  = flag.Bool("futures", true, "to disable futures use --futures=false")
+	splurges = flag.Bool("splurges", true, "to disable splurges use --splurges=false")
+	options  = flag.Bool("options", true, "to disable options use --options=false")
+)
+
+// bot is one entrant in the tournament.
+type bot struct {
+	Command string
+
+	// Name is the name from the bot's most recent handshake.
+	Name string
+
+	// Points are awarded for every opponent beaten in a game, and half a
+	// point for every draw.
+	Points float64
+	Games  int
+	Total  int64
+}
+
+type gameMap struct {
+	Name  string
+	Class string
+	Map   *protocol.Map
+}
+
+type tournament struct {
+	Bots     []*bot
+	Maps     []*gameMap
+	Seats    int
+	Rounds   int
+	Parallel int
+	Config   arena.Config
+
+	Ratings     Ratings
+	RatingsPath string
+
+	// played is the number of matches played so far.
+	played int
+}
+
+type result struct {
+	*match
+	Names  []string
+	Scores []protocol.Score
+}
+
+// loadMaps loads every map in dir in one of classes, or all maps if classes
+// is empty.
+func loadMaps(dir string, classes []string) ([]*gameMap, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var maps []*gameMap
+	for _, path := range paths {
+		m, err := arena.LoadMap(path)
+		if err != nil {
+			glog.Warningf("Skipping %s: %v", path, err)
+			continue
+		}
+
+		gm := &gameMap{
+			Name:  strings.TrimSuffix(filepath.Base(path), ".json"),
+			Class: sizeClass(m),
+			Map:   m,
+		}
+		if len(classes) > 0 && !contains(classes, gm.Class) {
+			continue
+		}
+		maps = append(maps, gm)
+	}
+
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no maps to play in %s", dir)
+	}
+	return maps, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// run plays a single match.
+func (t *tournament) run(m *match) *result {
+	commands := make([]string, len(m.Seats))
+	for i, b := range m.Seats {
+		commands[i] = t.Bots[b].Command
+	}
+
+	g := arena.New(m.Map.Map, t.Config, commands)
+	g.Name = fmt.Sprintf("[game %d] ", m.Index)
+
+	r := &result{
+		match:  m,
+		Scores: g.Run(),
+	}
+	for _, p := range g.Punters {
+		r.Names = append(r.Names, p.Bot.Name)
+	}
+	return r
+}
+
+// play plays matches, t.Parallel at a time. Results are recorded in schedule
+// order regardless of the order in which games finish, so ratings don't
+// depend on timing.
+func (t *tournament) play(matches []*match) {
+	for i, m := range matches {
+		m.Index = t.played + i
+	}
+
+	work := make(chan *match)
+	done := make(chan *result)
+
+	for i := 0; i < t.Parallel; i++ {
+		go func() {
+			for m := range work {
+				done <- t.run(m)
+			}
+		}()
+	}
+	go func() {
+		for _, m := range matches {
+			work <- m
+		}
+		close(work)
+	}()
+
+	pending := make(map[int]*result)
+	for range matches {
+		r := <-done
+		pending[r.Index] = r
+
+		for {
+			r, ok := pending[t.played]
+			if !ok {
+				break
+			}
+			delete(pending, t.played)
+			t.played++
+
+			t.record(r)
+		}
+	}
+}
+
+// record updates standings and ratings with the result of a match.
+func (t *tournament) record(r *result) {
+	var line []string
+	for i, b := range r.Seats {
+		line = append(line, fmt.Sprintf("%s=%d", t.Bots[b].Command, r.Scores[i].Score))
+	}
+	fmt.Printf("game %d (round %d, %s): %s\n", r.Index, r.Round, r.Map.Name, strings.Join(line, " "))
+
+	commands := make([]string, len(r.Seats))
+	for i, b := range r.Seats {
+		bot := t.Bots[b]
+		commands[i] = bot.Command
+
+		if r.Names[i] != "" {
+			bot.Name = r.Names[i]
+		}
+		bot.Games++
+		bot.Total += r.Scores[i].Score
+
+		for j := range r.Seats {
+			switch {
+			case i == j:
+			case r.Scores[i].Score > r.Scores[j].Score:
+				bot.Points++
+			case r.Scores[i].Score == r.Scores[j].Score:
+				bot.Points += 0.5
+			}
+		}
+	}
+
+	t.Ratings.update(r.Map.Class, commands, r.Scores)
+	if err := t.Ratings.save(t.RatingsPath); err != nil {
+		glog.Errorf("Failed to save ratings: %v", err)
+	}
+}
+
+// byRating sorts bot commands by conservative rating.
+type byRating struct {
+	commands []string
+	ratings  map[string]*Rating
+}
+
+func (s byRating) Len() int      { return len(s.commands) }
+func (s byRating) Swap(i, j int) { s.commands[i], s.commands[j] = s.commands[j], s.commands[i] }
+func (s byRating) Less(i, j int) bool {
+	return s.ratings[s.commands[i]].Conservative() > s.ratings[s.commands[j]].Conservative()
+}
+
+func (t *tournament) report() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprint(w, "\nStandings\n\n")
+	fmt.Fprintln(w, "bot\tname\tgames\tpoints\tmean score")
+
+	order := make([]int, len(t.Bots))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Stable(byStanding{t.Bots, order})
+	for _, i := range order {
+		b := t.Bots[i]
+		mean := 0.0
+		if b.Games > 0 {
+			mean = float64(b.Total) / float64(b.Games)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%.1f\n", b.Command, b.Name, b.Games, b.Points, mean)
+	}
+
+	for _, class := range []string{small, medium, large} {
+		ratings, ok := t.Ratings[class]
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(w, "\nRatings on %s maps (all tournaments)\n\n", class)
+		fmt.Fprintln(w, "bot\tgames\telo\tskill\t95% interval")
+
+		var commands []string
+		for c := range ratings {
+			commands = append(commands, c)
+		}
+		sort.Strings(commands)
+		sort.Stable(byRating{commands, ratings})
+
+		for _, c := range commands {
+			r := ratings[c]
+			lo, hi := r.Interval()
+			fmt.Fprintf(w, "%s\t%d\t%.0f\t%.2f\t[%.2f, %.2f]\n", c, r.Games, r.Elo, r.Mu, lo, hi)
+		}
+	}
+}
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Fatal("usage: tournament [flags] bot-command bot-command...")
+	}
+	if *seats < 2 || *seats > flag.NArg() {
+		log.Fatalf("-seats must be between 2 and the number of bots (%d)", flag.NArg())
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+
+	var schedule scheduler
+	switch *format {
+	case "roundrobin":
+		schedule = roundRobin
+	case "swiss":
+		schedule = swiss
+	default:
+		log.Fatalf("Unknown tournament format %q", *format)
+	}
+
+	var cs []string
+	if *classes != "" {
+		cs = strings.Split(*classes, ",")
+	}
+	maps, err := loadMaps(*mapDir, cs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ratings, err := loadRatings(*ratingsPath)
+	if err != nil {
+		log.Fatalf("Failed to load ratings: %v", err)
+	}
+
+	t := &tournament{
+		Maps:     maps,
+		Seats:    *seats,
+		Rounds:   *rounds,
+		Parallel: *parallel,
+		Config: arena.Config{
+			Settings: protocol.Settings{
+				Futures:  *futures,
+				Splurges: *splurges,
+				Options:  *options,
+			},
+			SetupTimeout: *setupTimeout,
+			MoveTimeout:  *moveTimeout,
+			MaxTimeouts:  *maxTimeouts,
+		},
+		Ratings:     ratings,
+		RatingsPath: *ratingsPath,
+	}
+	for _, c := range flag.Args() {
+		t.Bots = append(t.Bots, &bot{Command: c})
+	}
+
+	for round := 0; ; round++ {
+		matches := schedule(t, round)
+		if matches == nil {
+			break
+		}
+		t.play(matches)
+	}
+
+	t.report()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Map size classes, by number of rivers. Bots often behave very differently
+// on large maps, where precomputation doesn't fit in the time limits, so
+// they are rated separately.
+const (
+	small  = "small"
+	medium = "medium"
+	large  = "large"
+)
+
+func sizeClass(m *protocol.Map) string {
+	switch n := len(m.Rivers); {
+	case n < 200:
+		return small
+	case n < 2000:
+		return medium
+	}
+	return large
+}
+
+// TrueSkill parameters, using the defaults from the paper.
+const (
+	initialMu    = 25.0
+	initialSigma = initialMu / 3
+	beta         = initialSigma / 2
+	tau          = initialSigma / 100
+
+	initialElo = 1500.0
+	eloK       = 32.0
+)
+
+// Rating is one bot's rating in one size class.
+type Rating struct {
+	Games int `json:"games"`
+
+	Elo float64 `json:"elo"`
+
+	// Mu and Sigma are the mean and standard deviation of the TrueSkill
+	// estimate of the bot's skill.
+	Mu    float64 `json:"mu"`
+	Sigma float64 `json:"sigma"`
+}
+
+func newRating() *Rating {
+	return &Rating{
+		Elo:   initialElo,
+		Mu:    initialMu,
+		Sigma: initialSigma,
+	}
+}
+
+// Interval returns the 95% confidence interval of the skill estimate.
+func (r *Rating) Interval() (lo, hi float64) {
+	return r.Mu - 1.96*r.Sigma, r.Mu + 1.96*r.Sigma
+}
+
+// Conservative is a skill estimate the bot very probably exceeds, used for
+// ranking so that bots with few games don't top the table by luck.
+func (r *Rating) Conservative() float64 {
+	return r.Mu - 3*r.Sigma
+}
+
+// Ratings are the ratings of every bot, by size class and bot command.
+type Ratings map[string]map[string]*Rating
+
+func loadRatings(path string) (Ratings, error) {
+	r := make(Ratings)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// save writes the ratings to path, replacing it only once they are
+// completely written.
+func (r Ratings) save(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (r Ratings) get(class, bot string) *Rating {
+	c, ok := r[class]
+	if !ok {
+		c = make(map[string]*Rating)
+		r[class] = c
+	}
+
+	rating, ok := c[bot]
+	if !ok {
+		rating = newRating()
+		c[bot] = rating
+	}
+	return rating
+}
+
+// update rates the result of a single game. bots and scores are indexed by
+// seat.
+//
+// Multiplayer games are treated as a pairwise comparison between every pair
+// of seats, each against the ratings from before the game.
+func (r Ratings) update(class string, bots []string, scores []protocol.Score) {
+	n := len(bots)
+
+	before := make([]Rating, n)
+	for i, b := range bots {
+		before[i] = *r.get(class, b)
+	}
+
+	for i, b := range bots {
+		rating := r.get(class, b)
+		rating.Games++
+
+		// Skill drifts over time, so the estimate should never become
+		// completely certain.
+		variance := before[i].Sigma*before[i].Sigma + tau*tau
+
+		elo := 0.0
+		factor := 1.0
+		for j := range bots {
+			if i == j {
+				continue
+			}
+
+			outcome := 0.5
+			if scores[i].Score > scores[j].Score {
+				outcome = 1
+			} else if scores[i].Score < scores[j].Score {
+				outcome = 0
+			}
+
+			expected := 1 / (1 + math.Pow(10, (before[j].Elo-before[i].Elo)/400))
+			elo += eloK / float64(n-1) * (outcome - expected)
+
+			// TrueSkill has no draw margin here; a draw tells us
+			// little, so skip it.
+			if outcome == 0.5 {
+				continue
+			}
+
+			c := math.Sqrt(2*beta*beta + variance + before[j].Sigma*before[j].Sigma)
+			sign := 1.0
+			if outcome == 0 {
+				sign = -1
+			}
+			t := sign * (before[i].Mu - before[j].Mu) / c
+			v, w := truncate(t)
+
+			rating.Mu += sign * variance / c * v
+			factor *= 1 - variance/(c*c)*w
+		}
+
+		rating.Elo += elo
+		rating.Sigma = math.Sqrt(variance * factor)
+	}
+}
+
+// truncate returns the TrueSkill mean and variance corrections for a win by
+// a margin of t standard deviations.
+func truncate(t float64) (v, w float64) {
+	cdf := 0.5 * math.Erfc(-t/math.Sqrt2)
+	pdf := math.Exp(-t*t/2) / math.Sqrt(2*math.Pi)
+
+	if cdf < 1e-300 {
+		// Asymptotically, for very unexpected results.
+		v = -t
+	} else {
+		v = pdf / cdf
+	}
+	return v, v * (v + t)
+}
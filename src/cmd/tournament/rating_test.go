@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+func scores(s ...int64) []protocol.Score {
+	var sv []protocol.Score
+	for i, score := range s {
+		sv = append(sv, protocol.Score{Punter: uint64(i), Score: score})
+	}
+	return sv
+}
+
+func TestUpdateWin(t *testing.T) {
+	r := make(Ratings)
+	r.update(small, []string{"winner", "loser"}, scores(10, 3))
+
+	w, l := r.get(small, "winner"), r.get(small, "loser")
+	if w.Games != 1 || l.Games != 1 {
+		t.Errorf("games %d and %d, want 1 each", w.Games, l.Games)
+	}
+	if w.Mu <= initialMu || l.Mu >= initialMu {
+		t.Errorf("mu %v for the winner and %v for the loser, from %v", w.Mu, l.Mu, initialMu)
+	}
+	if math.Abs((w.Mu-initialMu)+(l.Mu-initialMu)) > 1e-9 {
+		t.Errorf("mu moved by %v and %v, not symmetrically", w.Mu-initialMu, l.Mu-initialMu)
+	}
+	if w.Sigma >= initialSigma || l.Sigma >= initialSigma {
+		t.Errorf("sigma %v and %v, not shrunk from %v", w.Sigma, l.Sigma, initialSigma)
+	}
+	if w.Elo != initialElo+eloK/2 || l.Elo != initialElo-eloK/2 {
+		t.Errorf("elo %v and %v, want %v and %v", w.Elo, l.Elo, initialElo+eloK/2, initialElo-eloK/2)
+	}
+
+	// Beating the same bot again is less of a surprise.
+	mu := w.Mu
+	r.update(small, []string{"winner", "loser"}, scores(10, 3))
+	if gain := w.Mu - mu; gain <= 0 || gain >= mu-initialMu {
+		t.Errorf("second win gained %v mu, the first %v", gain, mu-initialMu)
+	}
+
+	if len(r[medium]) != 0 || len(r[large]) != 0 {
+		t.Errorf("other classes rated: %v", r)
+	}
+}
+
+func TestUpdateDraw(t *testing.T) {
+	r := make(Ratings)
+	r.update(small, []string{"a", "b"}, scores(5, 5))
+
+	// A draw tells TrueSkill nothing, so only the drift in skill over time
+	// widens the estimate.
+	for _, bot := range []string{"a", "b"} {
+		rating := r.get(small, bot)
+		if rating.Mu != initialMu {
+			t.Errorf("%s: mu %v after a draw, want %v", bot, rating.Mu, initialMu)
+		}
+		if want := math.Sqrt(initialSigma*initialSigma + tau*tau); rating.Sigma != want {
+			t.Errorf("%s: sigma %v after a draw, want %v", bot, rating.Sigma, want)
+		}
+		if rating.Elo != initialElo {
+			t.Errorf("%s: elo %v after a draw between equals, want %v", bot, rating.Elo, initialElo)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+)
+
+// match is one game in the schedule.
+type match struct {
+	// Index orders matches across the whole tournament.
+	Index int
+	Round int
+	Map   *gameMap
+
+	// Seats are indexes into the tournament's bots, in seat order.
+	Seats []int
+}
+
+// scheduler returns the matches for a round, or nil once the tournament is
+// over. Rounds are played one at a time, so a scheduler may look at the
+// standings after the previous round.
+type scheduler func(t *tournament, round int) []*match
+
+// roundRobin plays every combination of bots on every map, once in every
+// seat rotation, in a single round.
+func roundRobin(t *tournament, round int) []*match {
+	if round > 0 {
+		return nil
+	}
+
+	var matches []*match
+	for _, group := range combinations(len(t.Bots), t.Seats) {
+		for _, m := range t.Maps {
+			for r := 0; r < t.Seats; r++ {
+				matches = append(matches, &match{
+					Round: round,
+					Map:   m,
+					Seats: rotate(group, r),
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// swiss plays t.Rounds rounds. Each round, bots are sorted by their points
+// so far and seated with their neighbours, so that bots meet opponents of
+// similar strength. Every round uses the next map, and seats rotate between
+// rounds. If the bots don't divide evenly into games, the lowest placed sit
+// the round out.
+func swiss(t *tournament, round int) []*match {
+	if round >= t.Rounds {
+		return nil
+	}
+
+	order := make([]int, len(t.Bots))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Stable(byStanding{t.Bots, order})
+
+	var matches []*match
+	for i := 0; i+t.Seats <= len(order); i += t.Seats {
+		matches = append(matches, &match{
+			Round: round,
+			Map:   t.Maps[round%len(t.Maps)],
+			Seats: rotate(order[i:i+t.Seats], round%t.Seats),
+		})
+	}
+	return matches
+}
+
+type byStanding struct {
+	bots  []*bot
+	order []int
+}
+
+func (s byStanding) Len() int      { return len(s.order) }
+func (s byStanding) Swap(i, j int) { s.order[i], s.order[j] = s.order[j], s.order[i] }
+func (s byStanding) Less(i, j int) bool {
+	return s.bots[s.order[i]].Points > s.bots[s.order[j]].Points
+}
+
+// combinations returns every k-element subset of 0..n-1.
+func combinations(n, k int) [][]int {
+	var result [][]int
+
+	var build func(start int, prefix []int)
+	build = func(start int, prefix []int) {
+		if len(prefix) == k {
+			result = append(result, append([]int(nil), prefix...))
+			return
+		}
+		for i := start; i < n; i++ {
+			build(i+1, append(prefix, i))
+		}
+	}
+	build(0, nil)
+
+	return result
+}
+
+// rotate returns a copy of s rotated left by r.
+func rotate(s []int, r int) []int {
+	out := make([]int, len(s))
+	for i := range s {
+		out[i] = s[(i+r)%len(s)]
+	}
+	return out
+}
@@ -0,0 +1,438 @@
+// Package engine implements the rules of the game, independent of how moves
+// are communicated, so that servers, local arenas and bot search code all
+// share one implementation.
+package engine
+
+import (
+	"fmt"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// punter is the rules state of a single punter.
+type punter struct {
+	// futures are the accepted futures, at most one per mine.
+	futures []protocol.Future
+
+	// splurges is the splurge credit accrued by passing.
+	splurges int
+
+	// options is the number of options remaining.
+	options int
+}
+
+// GameState is the complete state of one game.
+type GameState struct {
+	Map        *protocol.Map
+	Settings   protocol.Settings
+	NumPunters int
+
+	// Graph tracks river ownership. It must only be modified through
+	// GameState.
+	Graph *graph.Graph
+
+	// Turn is the number of moves made so far.
+	Turn int
+
+	// History is every move made, in order.
+	History []protocol.Move
+
+	punters []punter
+
+	mines map[protocol.SiteID]bool
+
+	// dist are the distances from each mine to each site. They don't
+	// depend on ownership, so they are computed once, on demand.
+	dist graph.Distances
+}
+
+// New returns the initial state of a game on m.
+func New(m *protocol.Map, numPunters int, settings protocol.Settings) *GameState {
+	g := &GameState{
+		Map:        m,
+		Settings:   settings,
+		NumPunters: numPunters,
+		Graph:      graph.New(m, func(*graph.MetadataEdge) float64 { return 1.0 }),
+		punters:    make([]punter, numPunters),
+		mines:      make(map[protocol.SiteID]bool, len(m.Mines)),
+	}
+
+	for _, mine := range m.Mines {
+		g.mines[mine] = true
+	}
+
+	for i := range g.punters {
+		g.punters[i].options = len(m.Mines)
+	}
+
+	return g
+}
+
+// Current returns the punter whose turn it is.
+func (g *GameState) Current() uint64 {
+	return uint64(g.Turn % g.NumPunters)
+}
+
+// Done returns true once every move of the game has been made.
+func (g *GameState) Done() bool {
+	return g.Turn >= len(g.Map.Rivers)
+}
+
+// LastMoves returns the most recent move of each punter, as sent in the move
+// request. Punters that have not moved yet have passed.
+func (g *GameState) LastMoves() []protocol.Move {
+	moves := make([]protocol.Move, g.NumPunters)
+	for i := range moves {
+		moves[i] = protocol.Move{Pass: &protocol.Pass{Punter: uint64(i)}}
+	}
+
+	start := len(g.History) - g.NumPunters
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(g.History); i++ {
+		moves[uint64(i%g.NumPunters)] = g.History[i]
+	}
+
+	return moves
+}
+
+// SetFutures records the futures bid by punter during setup.
+//
+// Futures are ignored unless enabled in Settings, their source is a mine and
+// their target is a site that is not a mine. If several futures are bid on
+// the same mine, only the last counts. An error is returned for each future
+// ignored.
+func (g *GameState) SetFutures(punter uint64, futures []protocol.Future) []error {
+	p := &g.punters[punter]
+	p.futures = nil
+
+	var errs []error
+	for _, f := range futures {
+		switch {
+		case !g.Settings.Futures:
+			errs = append(errs, fmt.Errorf("future %+v ignored, futures are disabled", f))
+			continue
+		case !g.mines[f.Source]:
+			errs = append(errs, fmt.Errorf("future %+v ignored, source is not a mine", f))
+			continue
+		case g.mines[f.Target]:
+			errs = append(errs, fmt.Errorf("future %+v ignored, target is a mine", f))
+			continue
+		case g.Graph.Node(int64(f.Target)) == nil:
+			errs = append(errs, fmt.Errorf("future %+v ignored, target doesn't exist", f))
+			continue
+		}
+
+		replaced := false
+		for i := range p.futures {
+			if p.futures[i].Source == f.Source {
+				errs = append(errs, fmt.Errorf("future %+v replaced by %+v", p.futures[i], f))
+				p.futures[i] = f
+				replaced = true
+			}
+		}
+		if !replaced {
+			p.futures = append(p.futures, f)
+		}
+	}
+
+	return errs
+}
+
+// Futures returns the futures accepted for every punter.
+func (g *GameState) Futures() []protocol.PunterFutures {
+	futures := make([]protocol.PunterFutures, g.NumPunters)
+	for i := range futures {
+		futures[i] = protocol.PunterFutures{
+			Punter:  uint64(i),
+			Futures: append([]protocol.Future{}, g.punters[i].futures...),
+		}
+	}
+	return futures
+}
+
+// river returns the river between source and target, or nil if there is none.
+func (g *GameState) river(source, target protocol.SiteID) *graph.MetadataEdge {
+	e := g.Graph.EdgeBetween(g.Graph.Node(int64(source)), g.Graph.Node(int64(target)))
+	if e == nil {
+		return nil
+	}
+	return e.(*graph.MetadataEdge)
+}
+
+// ApplyMove makes move on behalf of punter and advances the turn. Whichever
+// punter the move itself names, it is made, validated and recorded as
+// punter's.
+//
+// An illegal move returns a *MoveError describing why, and leaves the state
+// unchanged. Callers should then apply a pass instead.
+func (g *GameState) ApplyMove(punter uint64, move protocol.Move) error {
+	move = madeBy(punter, move)
+
+	if g.Done() {
+		return reject(punter, move, GameOver, "the game is over")
+	}
+	if punter != g.Current() {
+		return reject(punter, move, NotYourTurn, "it is punter %d's turn", g.Current())
+	}
+
+	var err *MoveError
+	switch {
+	case move.Claim != nil:
+		err = g.claim(punter, move)
+	case move.Splurge != nil:
+		err = g.splurge(punter, move)
+	case move.Option != nil:
+		err = g.option(punter, move)
+	default:
+		// If it is nothing else, then assume Pass. Each pass
+		// accrues credit towards a splurge.
+		g.punters[punter].splurges++
+		move = protocol.Move{Pass: &protocol.Pass{Punter: punter}}
+	}
+	if err != nil {
+		return err
+	}
+
+	g.History = append(g.History, move)
+	g.Turn++
+
+	return nil
+}
+
+// madeBy returns a copy of move, as made by punter.
+func madeBy(punter uint64, move protocol.Move) protocol.Move {
+	switch {
+	case move.Claim != nil:
+		c := *move.Claim
+		c.Punter = punter
+		return protocol.Move{Claim: &c}
+	case move.Splurge != nil:
+		s := *move.Splurge
+		s.Punter = punter
+		return protocol.Move{Splurge: &s}
+	case move.Option != nil:
+		o := *move.Option
+		o.Punter = punter
+		return protocol.Move{Option: &o}
+	case move.Pass != nil:
+		return protocol.Move{Pass: &protocol.Pass{Punter: punter}}
+	}
+	return move
+}
+
+// Pass makes a pass on behalf of punter.
+func (g *GameState) Pass(punter uint64) error {
+	return g.ApplyMove(punter, protocol.Move{Pass: &protocol.Pass{Punter: punter}})
+}
+
+// Credit returns the number of additional rivers punter may claim in its next
+// splurge.
+func (g *GameState) Credit(punter uint64) int {
+	return g.punters[punter].splurges
+}
+
+// Options returns the number of options punter has left.
+func (g *GameState) Options(punter uint64) int {
+	return g.punters[punter].options
+}
+
+func (g *GameState) claim(punter uint64, move protocol.Move) *MoveError {
+	claim := move.Claim
+
+	river := g.river(claim.Source, claim.Target)
+	if river == nil {
+		return reject(punter, move, NoSuchRiver, "claimed a river that doesn't exist").at(claim.Source, claim.Target)
+	}
+
+	if river.IsOwned {
+		return reject(punter, move, AlreadyClaimed, "claimed a river that has already been claimed by %d", river.OwnerPunter).at(claim.Source, claim.Target)
+	}
+
+	g.Graph.Update([]protocol.Move{move})
+
+	return nil
+}
+
+// splurge claims every river along the route at once.
+//
+// A route of n rivers costs n-1 credits, accrued by passing. Rivers owned by
+// other punters may be included if an option can be bought on them, which
+// uses one of the punter's options.
+func (g *GameState) splurge(punter uint64, move protocol.Move) *MoveError {
+	p := &g.punters[punter]
+	route := move.Splurge.Route
+
+	if !g.Settings.Splurges {
+		return reject(punter, move, SplurgesDisabled, "tried to splurge, but splurging is disabled")
+	}
+
+	if len(route) < 2 {
+		return reject(punter, move, RouteTooShort, "tried to splurge, but did not specify enough sites")
+	}
+
+	rivers := len(route) - 1
+	if rivers-1 > p.splurges {
+		return reject(punter, move, InsufficientCredit, "tried to splurge, but does not have enough credit (needs: %d, has: %d)", rivers-1, p.splurges)
+	}
+
+	// Validate the whole route before changing anything.
+	seen := make(map[*graph.MetadataEdge]bool, rivers)
+	optionsNeeded := 0
+	for i := 0; i < rivers; i++ {
+		src, tgt := route[i], route[i+1]
+
+		edge := g.river(src, tgt)
+		if edge == nil {
+			return reject(punter, move, NoSuchRiver, "tried to splurge along a river that doesn't exist").at(src, tgt)
+		}
+
+		if seen[edge] {
+			return reject(punter, move, DuplicateRiver, "tried to splurge along the same river twice").at(src, tgt)
+		}
+		seen[edge] = true
+
+		if edge.IsOwned {
+			if reason, detail := g.checkOption(punter, edge); reason != "" {
+				return reject(punter, move, reason, "tried to splurge along an owned river, but %s", detail).at(src, tgt)
+			}
+
+			optionsNeeded++
+		}
+	}
+
+	if optionsNeeded > p.options {
+		return reject(punter, move, NotEnoughOptions, "tried to splurge, but does not have enough options (needs: %d, have: %d)", optionsNeeded, p.options)
+	}
+
+	// Update buys options on the owned rivers.
+	g.Graph.Update([]protocol.Move{move})
+
+	p.options -= optionsNeeded
+	p.splurges -= rivers - 1
+
+	return nil
+}
+
+// checkOption returns the reason punter may not buy an option on edge, or an
+// empty Reason if it may.
+//
+// Options may only be bought on rivers owned by another punter, and each
+// river may only be optioned once.
+func (g *GameState) checkOption(punter uint64, edge *graph.MetadataEdge) (Reason, string) {
+	if !g.Settings.Options {
+		return OptionsDisabled, "options are disabled"
+	}
+
+	if !edge.IsOwned {
+		return NotOwned, "the river isn't owned, so it should be claimed instead"
+	}
+
+	if edge.OwnerPunter == punter {
+		return OwnedByPunter, "the river is already owned by this punter"
+	}
+
+	if edge.IsOptioned {
+		return AlreadyOptioned, fmt.Sprintf("the option has already been bought by %d", edge.OptionPunter)
+	}
+
+	return "", ""
+}
+
+func (g *GameState) option(punter uint64, move protocol.Move) *MoveError {
+	p := &g.punters[punter]
+	option := move.Option
+
+	if !g.Settings.Options {
+		return reject(punter, move, OptionsDisabled, "tried to option, but options is disabled")
+	}
+
+	if p.options < 1 {
+		return reject(punter, move, NoOptionsLeft, "tried to option, but has no options remaining")
+	}
+
+	edge := g.river(option.Source, option.Target)
+	if edge == nil {
+		return reject(punter, move, NoSuchRiver, "tried to option a river that doesn't exist").at(option.Source, option.Target)
+	}
+
+	if reason, detail := g.checkOption(punter, edge); reason != "" {
+		return reject(punter, move, reason, "tried to option, but %s", detail).at(option.Source, option.Target)
+	}
+
+	p.options--
+	g.Graph.Update([]protocol.Move{move})
+
+	return nil
+}
+
+// LegalMoves returns the claims and options punter may make, plus a pass.
+//
+// Splurges are not included, as there are too many routes to enumerate.
+func (g *GameState) LegalMoves(punter uint64) []protocol.Move {
+	canOption := g.punters[punter].options > 0
+
+	var moves []protocol.Move
+	for _, e := range g.Graph.Edges() {
+		river := e.(*graph.MetadataEdge)
+		source := protocol.SiteID(river.From().ID())
+		target := protocol.SiteID(river.To().ID())
+
+		switch {
+		case !river.IsOwned:
+			moves = append(moves, protocol.Move{Claim: &protocol.Claim{
+				Punter: punter,
+				Source: source,
+				Target: target,
+			}})
+		case canOption:
+			if reason, _ := g.checkOption(punter, river); reason != "" {
+				continue
+			}
+			moves = append(moves, protocol.Move{Option: &protocol.Option{
+				Punter: punter,
+				Source: source,
+				Target: target,
+			}})
+		}
+	}
+
+	return append(moves, protocol.Move{Pass: &protocol.Pass{Punter: punter}})
+}
+
+// Scores returns the score of every punter for the current ownership.
+//
+// Each site connected to a mine scores the square of its distance from the
+// mine. Each future scores the cube of the distance between its mine and
+// target if they are connected, and loses as much if they are not.
+//
+// The graph keeps the scores up to date from the first call onwards, so
+// calling Scores every turn is cheap. graph.Evaluate gives the same scores,
+// broken down, but works them out from scratch.
+func (g *GameState) Scores() []protocol.Score {
+	if g.dist == nil {
+		g.dist = g.Graph.ShortestDistances(g.Map.Mines)
+
+		dist := g.dist
+		g.Graph.TrackScore(g.Map.Mines, func(p uint64, mine, site protocol.SiteID) int64 {
+			d := int64(dist[mine][site])
+			return d * d
+		})
+	}
+
+	sv := make([]protocol.Score, g.NumPunters)
+	for i := range sv {
+		score := &sv[i]
+		score.Punter = uint64(i)
+		score.Score = g.Graph.RunningScore(score.Punter)
+
+		for _, f := range g.punters[i].futures {
+			d := int64(g.dist[f.Source][f.Target])
+			score.Score += graph.ScoreFuture(d, g.Graph.Connected(score.Punter, f.Source, f.Target))
+		}
+	}
+
+	return sv
+}
@@ -0,0 +1,73 @@
+package engine_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/engine"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+var maps = []string{
+	"sample.json",
+	"lambda.json",
+	"circle.json",
+}
+
+func loadMap(t *testing.T, name string) *protocol.Map {
+	b, err := ioutil.ReadFile(filepath.Join("../../maps", name))
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+// TestScoresMatchEvaluate plays random games, with futures and options, and
+// checks that the engine's running scores agree with graph.Evaluate after
+// every move.
+func TestScoresMatchEvaluate(t *testing.T) {
+	settings := protocol.Settings{Futures: true, Options: true}
+	const punters = 3
+
+	for _, name := range maps {
+		m := loadMap(t, name)
+		rng := rand.New(rand.NewSource(1))
+
+		g := engine.New(m, punters, settings)
+		for p := uint64(0); p < punters; p++ {
+			var futures []protocol.Future
+			for _, mine := range m.Mines {
+				futures = append(futures, protocol.Future{
+					Source: mine,
+					Target: m.Sites[rng.Intn(len(m.Sites))].ID,
+				})
+			}
+			// Futures on mines are refused, which is fine.
+			g.SetFutures(p, futures)
+		}
+
+		for !g.Done() {
+			p := g.Current()
+			legal := g.LegalMoves(p)
+			if err := g.ApplyMove(p, legal[rng.Intn(len(legal))]); err != nil {
+				t.Fatalf("%s: legal move refused: %v", name, err)
+			}
+
+			board := *m
+			board.Rivers = g.Graph.SerializeRivers()
+			want := graph.Totals(graph.Evaluate(&board, punters, g.Futures(), settings))
+			if got := g.Scores(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: turn %d: scores %v, Evaluate gives %v", name, g.Turn, got, want)
+			}
+		}
+	}
+}
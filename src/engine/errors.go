@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Reason identifies why a move was rejected.
+type Reason string
+
+const (
+	GameOver    Reason = "game_over"
+	NotYourTurn Reason = "not_your_turn"
+
+	NoSuchRiver    Reason = "no_such_river"
+	AlreadyClaimed Reason = "already_claimed"
+
+	SplurgesDisabled   Reason = "splurges_disabled"
+	RouteTooShort      Reason = "route_too_short"
+	DuplicateRiver     Reason = "duplicate_river"
+	InsufficientCredit Reason = "insufficient_credit"
+
+	OptionsDisabled  Reason = "options_disabled"
+	NoOptionsLeft    Reason = "no_options_left"
+	NotOwned         Reason = "not_owned"
+	OwnedByPunter    Reason = "owned_by_punter"
+	AlreadyOptioned  Reason = "already_optioned"
+	NotEnoughOptions Reason = "not_enough_options"
+)
+
+// MoveError is returned by GameState.ApplyMove for an illegal move.
+type MoveError struct {
+	Punter uint64        `json:"punter"`
+	Move   protocol.Move `json:"move"`
+	Reason Reason        `json:"reason"`
+
+	// River is the offending river, if the move was rejected because of
+	// a particular river.
+	River *protocol.River `json:"river,omitempty"`
+
+	// Detail is a human readable explanation.
+	Detail string `json:"detail"`
+}
+
+func (e *MoveError) Error() string {
+	if e.River != nil {
+		return fmt.Sprintf("move %v rejected (%s) at river {%d, %d}: %s", e.Move, e.Reason, e.River.Source, e.River.Target, e.Detail)
+	}
+	return fmt.Sprintf("move %v rejected (%s): %s", e.Move, e.Reason, e.Detail)
+}
+
+// reject returns a MoveError for move.
+func reject(punter uint64, move protocol.Move, reason Reason, format string, v ...interface{}) *MoveError {
+	return &MoveError{
+		Punter: punter,
+		Move:   move,
+		Reason: reason,
+		Detail: fmt.Sprintf(format, v...),
+	}
+}
+
+// at records the river responsible for e.
+func (e *MoveError) at(source, target protocol.SiteID) *MoveError {
+	e.River = &protocol.River{Source: source, Target: target}
+	return e
+}
@@ -0,0 +1,199 @@
+package graph
+
+import (
+	"sort"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Criticality describes the weak points of the rivers still available to a
+// punter: those that nobody owns, plus those the punter already holds.
+// Rivers owned by other punters are treated as gone, even if the punter could
+// buy an option on them.
+type Criticality struct {
+	// Bridges are the available rivers whose loss would split the
+	// available network in two.
+	Bridges []*MetadataEdge
+
+	// ArticulationPoints are the sites whose loss would split the
+	// available network.
+	ArticulationPoints []protocol.SiteID
+
+	// Component numbers each site's 2-edge-connected component: sites in
+	// the same component stay connected if any one river is claimed by
+	// someone else.
+	Component map[protocol.SiteID]int
+
+	bridges      map[*MetadataEdge]bool
+	articulation map[protocol.SiteID]bool
+}
+
+// IsBridge returns true if e is one of c.Bridges.
+func (c *Criticality) IsBridge(e *MetadataEdge) bool {
+	return c.bridges[e]
+}
+
+// IsArticulationPoint returns true if site is one of c.ArticulationPoints.
+func (c *Criticality) IsArticulationPoint(site protocol.SiteID) bool {
+	return c.articulation[site]
+}
+
+// SameComponent returns true if a and b are in the same 2-edge-connected
+// component.
+func (c *Criticality) SameComponent(a, b protocol.SiteID) bool {
+	return c.Component[a] == c.Component[b]
+}
+
+// Critical returns the Criticality of the rivers available to punter. The
+// result is cached until the next Update, and must not be modified.
+func (g *Graph) Critical(punter uint64) *Criticality {
+	if g.critical == nil || g.criticalVersion != g.version {
+		g.critical = make(map[uint64]*Criticality)
+		g.criticalVersion = g.version
+	}
+
+	c, ok := g.critical[punter]
+	if !ok {
+		c = g.analyse(punter)
+		g.critical[punter] = c
+	}
+	return c
+}
+
+type arc struct {
+	to   int32
+	edge int32
+}
+
+// bridgeFinder runs Tarjan's bridge and articulation point algorithm.
+type bridgeFinder struct {
+	adj   [][]arc
+	disc  []int32
+	low   []int32
+	timer int32
+
+	bridge       []bool
+	articulation []bool
+}
+
+func (f *bridgeFinder) visit(u int32, parentEdge int32) {
+	f.timer++
+	f.disc[u] = f.timer
+	f.low[u] = f.timer
+
+	children := 0
+	for _, a := range f.adj[u] {
+		if a.edge == parentEdge {
+			continue
+		}
+
+		if f.disc[a.to] != 0 {
+			if f.disc[a.to] < f.low[u] {
+				f.low[u] = f.disc[a.to]
+			}
+			continue
+		}
+
+		children++
+		f.visit(a.to, a.edge)
+		if f.low[a.to] < f.low[u] {
+			f.low[u] = f.low[a.to]
+		}
+
+		if f.low[a.to] > f.disc[u] {
+			f.bridge[a.edge] = true
+		}
+		if parentEdge >= 0 && f.low[a.to] >= f.disc[u] {
+			f.articulation[u] = true
+		}
+	}
+
+	if parentEdge < 0 && children > 1 {
+		f.articulation[u] = true
+	}
+}
+
+func (g *Graph) analyse(punter uint64) *Criticality {
+	sites := g.connectivity.sites
+	index := g.connectivity.index
+
+	var edges []*MetadataEdge
+	f := &bridgeFinder{
+		adj:          make([][]arc, len(sites)),
+		disc:         make([]int32, len(sites)),
+		low:          make([]int32, len(sites)),
+		articulation: make([]bool, len(sites)),
+	}
+	for _, e := range g.Edges() {
+		me := e.(*MetadataEdge)
+		if me.IsOwned && !me.HeldBy(punter) {
+			continue
+		}
+
+		id := int32(len(edges))
+		edges = append(edges, me)
+
+		a := index[protocol.SiteID(me.F.ID())]
+		b := index[protocol.SiteID(me.T.ID())]
+		f.adj[a] = append(f.adj[a], arc{b, id})
+		f.adj[b] = append(f.adj[b], arc{a, id})
+	}
+	f.bridge = make([]bool, len(edges))
+
+	for i := range sites {
+		if f.disc[i] == 0 {
+			f.visit(int32(i), -1)
+		}
+	}
+
+	c := &Criticality{
+		Component:    make(map[protocol.SiteID]int, len(sites)),
+		bridges:      make(map[*MetadataEdge]bool),
+		articulation: make(map[protocol.SiteID]bool),
+	}
+	for i, e := range edges {
+		if f.bridge[i] {
+			c.Bridges = append(c.Bridges, e)
+			c.bridges[e] = true
+		}
+	}
+	for i, ok := range f.articulation {
+		if ok {
+			c.ArticulationPoints = append(c.ArticulationPoints, sites[i])
+			c.articulation[sites[i]] = true
+		}
+	}
+	sort.Sort(bySiteID(c.ArticulationPoints))
+
+	// Removing the bridges leaves the 2-edge-connected components.
+	component := make([]int, len(sites))
+	for i := range component {
+		component[i] = -1
+	}
+	next := 0
+	var stack []int32
+	for i := range sites {
+		if component[i] >= 0 {
+			continue
+		}
+
+		component[i] = next
+		stack = append(stack[:0], int32(i))
+		for len(stack) > 0 {
+			u := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, a := range f.adj[u] {
+				if !f.bridge[a.edge] && component[a.to] < 0 {
+					component[a.to] = next
+					stack = append(stack, a.to)
+				}
+			}
+		}
+		next++
+	}
+	for i, id := range sites {
+		c.Component[id] = component[i]
+	}
+
+	return c
+}
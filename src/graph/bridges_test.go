@@ -0,0 +1,188 @@
+package graph_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// rivers returns a map of the sites named in pairs, joined by a river for
+// each pair.
+func rivers(pairs ...[2]protocol.SiteID) protocol.Map {
+	var m protocol.Map
+	seen := make(map[protocol.SiteID]bool)
+	for _, p := range pairs {
+		for _, s := range p {
+			if !seen[s] {
+				seen[s] = true
+				m.Sites = append(m.Sites, protocol.Site{ID: s})
+			}
+		}
+		m.Rivers = append(m.Rivers, protocol.River{Source: p[0], Target: p[1]})
+	}
+	return m
+}
+
+// held returns m with the river between a and b owned by owner, and, if
+// option is not negative, optioned by option.
+func held(m protocol.Map, a, b protocol.SiteID, owner uint64, option int) protocol.Map {
+	m.Rivers = append([]protocol.River(nil), m.Rivers...)
+	for i := range m.Rivers {
+		r := &m.Rivers[i]
+		if r.Source == a && r.Target == b || r.Source == b && r.Target == a {
+			r.IsOwned, r.OwnerPunter = true, owner
+			if option >= 0 {
+				r.IsOptioned, r.OptionPunter = true, uint64(option)
+			}
+		}
+	}
+	return m
+}
+
+type sitePairs [][2]protocol.SiteID
+
+func (s sitePairs) Len() int      { return len(s) }
+func (s sitePairs) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sitePairs) Less(i, j int) bool {
+	if s[i][0] != s[j][0] {
+		return s[i][0] < s[j][0]
+	}
+	return s[i][1] < s[j][1]
+}
+
+var (
+	path4    = rivers([2]protocol.SiteID{0, 1}, [2]protocol.SiteID{1, 2}, [2]protocol.SiteID{2, 3})
+	cycle4   = rivers([2]protocol.SiteID{0, 1}, [2]protocol.SiteID{1, 2}, [2]protocol.SiteID{2, 3}, [2]protocol.SiteID{3, 0})
+	twoLoops = rivers(
+		[2]protocol.SiteID{0, 1}, [2]protocol.SiteID{1, 2}, [2]protocol.SiteID{2, 0},
+		[2]protocol.SiteID{2, 3},
+		[2]protocol.SiteID{3, 4}, [2]protocol.SiteID{4, 5}, [2]protocol.SiteID{5, 3},
+	)
+)
+
+var criticalTests = []struct {
+	name   string
+	m      protocol.Map
+	punter uint64
+
+	bridges      sitePairs
+	articulation []protocol.SiteID
+	// components groups the sites by 2-edge-connected component.
+	components [][]protocol.SiteID
+}{
+	{
+		name:         "path",
+		m:            path4,
+		bridges:      sitePairs{{0, 1}, {1, 2}, {2, 3}},
+		articulation: []protocol.SiteID{1, 2},
+		components:   [][]protocol.SiteID{{0}, {1}, {2}, {3}},
+	},
+	{
+		name:       "cycle",
+		m:          cycle4,
+		components: [][]protocol.SiteID{{0, 1, 2, 3}},
+	},
+	{
+		name:         "two cycles joined by one river",
+		m:            twoLoops,
+		bridges:      sitePairs{{2, 3}},
+		articulation: []protocol.SiteID{2, 3},
+		components:   [][]protocol.SiteID{{0, 1, 2}, {3, 4, 5}},
+	},
+	{
+		name:         "cycle with an opponent's river",
+		m:            held(cycle4, 0, 1, 1, -1),
+		bridges:      sitePairs{{1, 2}, {2, 3}, {0, 3}},
+		articulation: []protocol.SiteID{2, 3},
+		components:   [][]protocol.SiteID{{0}, {1}, {2}, {3}},
+	},
+	{
+		name:       "cycle with our own river",
+		m:          held(cycle4, 0, 1, 0, -1),
+		components: [][]protocol.SiteID{{0, 1, 2, 3}},
+	},
+	{
+		name:       "cycle with our option on an opponent's river",
+		m:          held(cycle4, 0, 1, 1, 0),
+		components: [][]protocol.SiteID{{0, 1, 2, 3}},
+	},
+	{
+		name:       "two cycles cut apart by an opponent",
+		m:          held(twoLoops, 2, 3, 1, -1),
+		components: [][]protocol.SiteID{{0, 1, 2}, {3, 4, 5}},
+	},
+}
+
+func TestCritical(t *testing.T) {
+	for _, tt := range criticalTests {
+		g := graph.New(&tt.m, unitWeight)
+		c := g.Critical(tt.punter)
+
+		var bridges sitePairs
+		for _, e := range c.Bridges {
+			a, b := protocol.SiteID(e.F.ID()), protocol.SiteID(e.T.ID())
+			if a > b {
+				a, b = b, a
+			}
+			bridges = append(bridges, [2]protocol.SiteID{a, b})
+			if !c.IsBridge(e) {
+				t.Errorf("%s: IsBridge false for bridge %d-%d", tt.name, a, b)
+			}
+		}
+		sort.Sort(bridges)
+		want := append(sitePairs(nil), tt.bridges...)
+		sort.Sort(want)
+		if !reflect.DeepEqual(bridges, want) {
+			t.Errorf("%s: bridges %v, want %v", tt.name, bridges, want)
+		}
+
+		if !reflect.DeepEqual(c.ArticulationPoints, tt.articulation) {
+			t.Errorf("%s: articulation points %v, want %v", tt.name, c.ArticulationPoints, tt.articulation)
+		}
+		for _, s := range tt.m.Sites {
+			want := false
+			for _, a := range tt.articulation {
+				want = want || a == s.ID
+			}
+			if c.IsArticulationPoint(s.ID) != want {
+				t.Errorf("%s: IsArticulationPoint(%d) = %v", tt.name, s.ID, !want)
+			}
+		}
+
+		for i, ci := range tt.components {
+			for j, cj := range tt.components {
+				for _, a := range ci {
+					for _, b := range cj {
+						if got := c.SameComponent(a, b); got != (i == j) {
+							t.Errorf("%s: SameComponent(%d, %d) = %v", tt.name, a, b, got)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCriticalAfterUpdate(t *testing.T) {
+	m := cycle4
+	g := graph.New(&m, unitWeight)
+
+	c := g.Critical(0)
+	if g.Critical(0) != c {
+		t.Error("Criticality not cached between updates")
+	}
+	if len(c.Bridges) != 0 {
+		t.Fatalf("bridges %v in a cycle", c.Bridges)
+	}
+
+	g.Update([]protocol.Move{{Claim: &protocol.Claim{Punter: 1, Source: 0, Target: 1}}})
+	if c = g.Critical(0); len(c.Bridges) != 3 {
+		t.Errorf("%d bridges once an opponent cut the cycle, want 3", len(c.Bridges))
+	}
+	if c = g.Critical(1); len(c.Bridges) != 0 {
+		t.Errorf("%d bridges for the punter who claimed the river, want 0", len(c.Bridges))
+	}
+}
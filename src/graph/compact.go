@@ -0,0 +1,280 @@
+package graph
+
+import (
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// NoPunter marks a river that is not owned or optioned in a Compact.
+const NoPunter = -1
+
+// Compact is an array-based representation of a map, for code that needs to
+// walk the graph many times per move.
+//
+// Sites and rivers are identified by dense indexes rather than by ID, so
+// per-site and per-river data can be kept in slices. The adjacency lists
+// are stored in compressed sparse row form: the neighbours of site i are
+// Adj[Offsets[i]:Offsets[i+1]], joined by the rivers at the same positions
+// in AdjRiver.
+type Compact struct {
+	// Sites maps site indexes to IDs, and Index maps IDs to indexes.
+	Sites []protocol.SiteID
+	Index map[protocol.SiteID]int32
+
+	// Mines are the site indexes of the mines.
+	Mines []int32
+
+	Offsets  []int32
+	Adj      []int32
+	AdjRiver []int32
+
+	// Source and Target are the site indexes at either end of each
+	// river.
+	Source []int32
+	Target []int32
+
+	// Owner and Option are the punters that own and hold the option on
+	// each river, or NoPunter.
+	Owner  []int32
+	Option []int32
+}
+
+// NewCompact returns a Compact representing m. Sites and rivers are indexed
+// in the order they appear in m.
+func NewCompact(m *protocol.Map) *Compact {
+	c := &Compact{
+		Sites:  make([]protocol.SiteID, len(m.Sites)),
+		Index:  make(map[protocol.SiteID]int32, len(m.Sites)),
+		Source: make([]int32, 0, len(m.Rivers)),
+		Target: make([]int32, 0, len(m.Rivers)),
+		Owner:  make([]int32, 0, len(m.Rivers)),
+		Option: make([]int32, 0, len(m.Rivers)),
+	}
+
+	for i, s := range m.Sites {
+		c.Sites[i] = s.ID
+		c.Index[s.ID] = int32(i)
+	}
+
+	for _, mine := range m.Mines {
+		if i, ok := c.Index[mine]; ok {
+			c.Mines = append(c.Mines, i)
+		}
+	}
+
+	for _, r := range m.Rivers {
+		src, ok := c.Index[r.Source]
+		if !ok {
+			glog.Warningf("River {%d, %d} has an unknown source", r.Source, r.Target)
+			continue
+		}
+		tgt, ok := c.Index[r.Target]
+		if !ok {
+			glog.Warningf("River {%d, %d} has an unknown target", r.Source, r.Target)
+			continue
+		}
+
+		owner, option := int32(NoPunter), int32(NoPunter)
+		if r.IsOwned {
+			owner = int32(r.OwnerPunter)
+		}
+		if r.IsOptioned {
+			option = int32(r.OptionPunter)
+		}
+
+		c.Source = append(c.Source, src)
+		c.Target = append(c.Target, tgt)
+		c.Owner = append(c.Owner, owner)
+		c.Option = append(c.Option, option)
+	}
+
+	c.buildAdjacency()
+
+	return c
+}
+
+// buildAdjacency fills in the adjacency lists from Source and Target.
+func (c *Compact) buildAdjacency() {
+	c.Offsets = make([]int32, len(c.Sites)+1)
+	for r := range c.Source {
+		c.Offsets[c.Source[r]+1]++
+		c.Offsets[c.Target[r]+1]++
+	}
+	for i := 1; i < len(c.Offsets); i++ {
+		c.Offsets[i] += c.Offsets[i-1]
+	}
+
+	c.Adj = make([]int32, 2*len(c.Source))
+	c.AdjRiver = make([]int32, 2*len(c.Source))
+
+	next := append([]int32(nil), c.Offsets[:len(c.Sites)]...)
+	add := func(from, to, river int32) {
+		c.Adj[next[from]] = to
+		c.AdjRiver[next[from]] = river
+		next[from]++
+	}
+	for r := range c.Source {
+		add(c.Source[r], c.Target[r], int32(r))
+		add(c.Target[r], c.Source[r], int32(r))
+	}
+}
+
+// Degree returns the number of rivers at site i.
+func (c *Compact) Degree(i int32) int {
+	return int(c.Offsets[i+1] - c.Offsets[i])
+}
+
+// Neighbours returns the sites adjacent to site i and the rivers leading to
+// them. The slices must not be modified.
+func (c *Compact) Neighbours(i int32) (sites, rivers []int32) {
+	lo, hi := c.Offsets[i], c.Offsets[i+1]
+	return c.Adj[lo:hi], c.AdjRiver[lo:hi]
+}
+
+// River returns the index of the river between sites a and b, or -1 if there
+// is none.
+func (c *Compact) River(a, b int32) int32 {
+	// Search from the end with fewer rivers.
+	if c.Degree(b) < c.Degree(a) {
+		a, b = b, a
+	}
+
+	sites, rivers := c.Neighbours(a)
+	for j, s := range sites {
+		if s == b {
+			return rivers[j]
+		}
+	}
+	return -1
+}
+
+// HeldBy returns true if punter owns river r or holds the option on it.
+func (c *Compact) HeldBy(r int32, punter int32) bool {
+	return c.Owner[r] == punter || c.Option[r] == punter
+}
+
+// Update adds the effect of the passed moves, as Graph.Update does.
+func (c *Compact) Update(moves []protocol.Move) {
+	for _, move := range moves {
+		var claim bool // true for claim, false for option.
+		var route []protocol.SiteID
+		var punter int32
+		switch {
+		case move.Claim != nil:
+			claim = true
+			route = []protocol.SiteID{move.Claim.Source, move.Claim.Target}
+			punter = int32(move.Claim.Punter)
+		case move.Splurge != nil:
+			claim = true
+			route = move.Splurge.Route
+			punter = int32(move.Splurge.Punter)
+		case move.Option != nil:
+			route = []protocol.SiteID{move.Option.Source, move.Option.Target}
+			punter = int32(move.Option.Punter)
+		}
+
+		for i := 0; i+1 < len(route); i++ {
+			a, ok := c.Index[route[i]]
+			if !ok {
+				glog.Warningf("Invalid site %d in move %v", route[i], move)
+				continue
+			}
+			b, ok := c.Index[route[i+1]]
+			if !ok {
+				glog.Warningf("Invalid site %d in move %v", route[i+1], move)
+				continue
+			}
+
+			r := c.River(a, b)
+			if r < 0 {
+				glog.Warningf("Invalid river {%d, %d} in move %v", route[i], route[i+1], move)
+				continue
+			}
+
+			// A splurge through a river owned by someone else
+			// uses an option.
+			if claim && (c.Owner[r] == NoPunter || c.Owner[r] == punter) {
+				c.Owner[r] = punter
+			} else {
+				c.Option[r] = punter
+			}
+		}
+	}
+}
+
+// Map returns the map c represents, including river ownership.
+func (c *Compact) Map() *protocol.Map {
+	m := &protocol.Map{
+		Sites:  make([]protocol.Site, len(c.Sites)),
+		Rivers: make([]protocol.River, len(c.Source)),
+		Mines:  make([]protocol.SiteID, len(c.Mines)),
+	}
+
+	for i, id := range c.Sites {
+		m.Sites[i].ID = id
+	}
+	for i, mine := range c.Mines {
+		m.Mines[i] = c.Sites[mine]
+	}
+	for r := range c.Source {
+		river := &m.Rivers[r]
+		river.Source = c.Sites[c.Source[r]]
+		river.Target = c.Sites[c.Target[r]]
+		if c.Owner[r] != NoPunter {
+			river.IsOwned = true
+			river.OwnerPunter = uint64(c.Owner[r])
+		}
+		if c.Option[r] != NoPunter {
+			river.IsOptioned = true
+			river.OptionPunter = uint64(c.Option[r])
+		}
+	}
+
+	return m
+}
+
+// Graph returns a Graph equivalent to c.
+func (c *Compact) Graph(weight WeightFunc) *Graph {
+	return New(c.Map(), weight)
+}
+
+type bySiteID []protocol.SiteID
+
+func (s bySiteID) Len() int           { return len(s) }
+func (s bySiteID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySiteID) Less(i, j int) bool { return s[i] < s[j] }
+
+type byEnds []protocol.River
+
+func (s byEnds) Len() int      { return len(s) }
+func (s byEnds) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byEnds) Less(i, j int) bool {
+	if s[i].Source != s[j].Source {
+		return s[i].Source < s[j].Source
+	}
+	return s[i].Target < s[j].Target
+}
+
+// Compact returns a Compact equivalent to g, with the given mines. Sites and
+// rivers are indexed in order of ID, since g has no order of its own.
+func (g *Graph) Compact(mines []protocol.SiteID) *Compact {
+	m := &protocol.Map{
+		Rivers: g.SerializeRivers(),
+		Mines:  mines,
+	}
+
+	var ids []protocol.SiteID
+	for _, n := range g.Nodes() {
+		ids = append(ids, protocol.SiteID(n.ID()))
+	}
+	sort.Sort(bySiteID(ids))
+	for _, id := range ids {
+		m.Sites = append(m.Sites, protocol.Site{ID: id})
+	}
+
+	sort.Sort(byEnds(m.Rivers))
+
+	return NewCompact(m)
+}
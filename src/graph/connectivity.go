@@ -0,0 +1,327 @@
+package graph
+
+import (
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// disjointSet is a union-find forest over site indexes, joining the sites
+// connected by one punter's rivers.
+type disjointSet struct {
+	parent []int32
+	size   []int32
+
+	// members and mines list the sites and mines in each set, indexed by
+	// the set's root. They are only kept while tracking scores.
+	members [][]int32
+	mines   [][]protocol.SiteID
+
+	// undoable sets are being changed by Graph.Apply, so that every union
+	// must be reversible. Path compression is suspended, and absorbed
+	// sets keep their member lists.
+	undoable bool
+}
+
+func newDisjointSet(n int) *disjointSet {
+	d := &disjointSet{
+		parent: make([]int32, n),
+		size:   make([]int32, n),
+	}
+	for i := range d.parent {
+		d.parent[i] = int32(i)
+		d.size[i] = 1
+	}
+	return d
+}
+
+func (d *disjointSet) find(x int32) int32 {
+	for d.parent[x] != x {
+		if !d.undoable {
+			// Path halving.
+			d.parent[x] = d.parent[d.parent[x]]
+		}
+		x = d.parent[x]
+	}
+	return x
+}
+
+// union joins the sets rooted at a and b, which must differ, returning the
+// new root.
+func (d *disjointSet) union(a, b int32) int32 {
+	if d.size[a] < d.size[b] {
+		a, b = b, a
+	}
+	d.parent[b] = a
+	d.size[a] += d.size[b]
+
+	if d.members != nil {
+		d.members[a] = append(d.members[a], d.members[b]...)
+		d.mines[a] = append(d.mines[a], d.mines[b]...)
+		if !d.undoable {
+			d.members[b] = nil
+			d.mines[b] = nil
+		}
+	}
+
+	return a
+}
+
+func (d *disjointSet) clone() *disjointSet {
+	c := &disjointSet{
+		parent: append([]int32(nil), d.parent...),
+		size:   append([]int32(nil), d.size...),
+	}
+	if d.members != nil {
+		c.members = make([][]int32, len(d.members))
+		c.mines = make([][]protocol.SiteID, len(d.mines))
+		for i := range d.members {
+			if d.parent[i] == int32(i) {
+				c.members[i] = append([]int32(nil), d.members[i]...)
+				c.mines[i] = append([]protocol.SiteID(nil), d.mines[i]...)
+			}
+		}
+	}
+	return c
+}
+
+// connectivity tracks which sites each punter has connected.
+type connectivity struct {
+	// sites maps site indexes to IDs, and index the reverse.
+	sites []protocol.SiteID
+	index map[protocol.SiteID]int32
+
+	// punters holds each punter's sets. A punter's sets are created when
+	// it first holds a river.
+	punters map[uint64]*disjointSet
+
+	// If points is not nil, scores holds each punter's score under points,
+	// kept up to date as rivers are joined.
+	mines  []protocol.SiteID
+	points func(punter uint64, mine, site protocol.SiteID) int64
+	scores map[uint64]int64
+
+	// undoable is set while Graph.Apply has moves to undo.
+	undoable bool
+}
+
+// unionRecord is enough to reverse one union.
+type unionRecord struct {
+	punter uint64
+
+	// root absorbed child, which had been a set of its own.
+	root, child int32
+	size        int32
+
+	// members and mines are the lengths of root's lists before the
+	// union.
+	members, mines int
+
+	score int64
+}
+
+func newConnectivity(m *protocol.Map) *connectivity {
+	c := &connectivity{
+		sites:   make([]protocol.SiteID, len(m.Sites)),
+		index:   make(map[protocol.SiteID]int32, len(m.Sites)),
+		punters: make(map[uint64]*disjointSet),
+	}
+	for i, s := range m.Sites {
+		c.sites[i] = s.ID
+		c.index[s.ID] = int32(i)
+	}
+	return c
+}
+
+func (c *connectivity) set(punter uint64) *disjointSet {
+	d, ok := c.punters[punter]
+	if !ok {
+		d = newDisjointSet(len(c.sites))
+		d.undoable = c.undoable
+		if c.points != nil {
+			c.trackMembers(d)
+		}
+		c.punters[punter] = d
+	}
+	return d
+}
+
+// trackMembers builds the member and mine lists of every set in d.
+func (c *connectivity) trackMembers(d *disjointSet) {
+	d.members = make([][]int32, len(c.sites))
+	d.mines = make([][]protocol.SiteID, len(c.sites))
+
+	for i := range c.sites {
+		root := d.find(int32(i))
+		d.members[root] = append(d.members[root], int32(i))
+	}
+	for _, mine := range c.mines {
+		if i, ok := c.index[mine]; ok {
+			root := d.find(i)
+			d.mines[root] = append(d.mines[root], mine)
+		}
+	}
+}
+
+// setUndoable starts or stops recording unions for undo.
+func (c *connectivity) setUndoable(undoable bool) {
+	c.undoable = undoable
+	for _, d := range c.punters {
+		d.undoable = undoable
+	}
+}
+
+// join records that punter holds a river between a and b. If rec is not
+// nil, the union is appended to it so it can be reversed by split.
+func (c *connectivity) join(punter uint64, a, b protocol.SiteID, rec *[]unionRecord) {
+	ia, ok := c.index[a]
+	if !ok {
+		return
+	}
+	ib, ok := c.index[b]
+	if !ok {
+		return
+	}
+
+	d := c.set(punter)
+	ra, rb := d.find(ia), d.find(ib)
+	if ra == rb {
+		return
+	}
+	if d.size[ra] < d.size[rb] {
+		ra, rb = rb, ra
+	}
+
+	u := unionRecord{
+		punter: punter,
+		root:   ra,
+		child:  rb,
+		size:   d.size[ra],
+	}
+
+	if c.points != nil {
+		u.members, u.mines = len(d.members[ra]), len(d.mines[ra])
+
+		// Each mine on either side now reaches every site on the
+		// other.
+		u.score = c.gain(punter, d.mines[ra], d.members[rb]) + c.gain(punter, d.mines[rb], d.members[ra])
+		c.scores[punter] += u.score
+	}
+
+	d.union(ra, rb)
+
+	if rec != nil {
+		*rec = append(*rec, u)
+	}
+}
+
+// split reverses a union recorded by join.
+func (c *connectivity) split(u unionRecord) {
+	d := c.punters[u.punter]
+	d.parent[u.child] = u.child
+	d.size[u.root] = u.size
+
+	if d.members != nil {
+		d.members[u.root] = d.members[u.root][:u.members]
+		d.mines[u.root] = d.mines[u.root][:u.mines]
+		c.scores[u.punter] -= u.score
+	}
+}
+
+func (c *connectivity) clone() *connectivity {
+	n := &connectivity{
+		sites:   c.sites,
+		index:   c.index,
+		punters: make(map[uint64]*disjointSet, len(c.punters)),
+		mines:   c.mines,
+		points:  c.points,
+	}
+	for p, d := range c.punters {
+		n.punters[p] = d.clone()
+	}
+	if c.scores != nil {
+		n.scores = make(map[uint64]int64, len(c.scores))
+		for p, s := range c.scores {
+			n.scores[p] = s
+		}
+	}
+	return n
+}
+
+func (c *connectivity) gain(punter uint64, mines []protocol.SiteID, sites []int32) int64 {
+	var total int64
+	for _, mine := range mines {
+		for _, s := range sites {
+			total += c.points(punter, mine, c.sites[s])
+		}
+	}
+	return total
+}
+
+// Connected reports whether punter holds a path of rivers between sites a
+// and b, in near constant time.
+func (g *Graph) Connected(punter uint64, a, b protocol.SiteID) bool {
+	if a == b {
+		return true
+	}
+
+	c := g.connectivity
+	d, ok := c.punters[punter]
+	if !ok {
+		return false
+	}
+	ia, ok := c.index[a]
+	if !ok {
+		return false
+	}
+	ib, ok := c.index[b]
+	if !ok {
+		return false
+	}
+	return d.find(ia) == d.find(ib)
+}
+
+// TrackScore starts keeping a running score for every punter, as Score would
+// compute it for mines and points, and updating it as Update joins sites.
+// points must not change over the life of the graph.
+func (g *Graph) TrackScore(mines []protocol.SiteID, points func(punter uint64, mine, site protocol.SiteID) int64) {
+	c := g.connectivity
+	c.mines = mines
+	c.points = points
+	c.scores = make(map[uint64]int64, len(c.punters))
+
+	for punter, d := range c.punters {
+		c.trackMembers(d)
+
+		var total int64
+		for root := range d.mines {
+			total += c.gain(punter, d.mines[root], d.members[root])
+		}
+		c.scores[punter] = total
+	}
+}
+
+// RunningScore returns punter's score as tracked since TrackScore, which
+// must have been called.
+func (g *Graph) RunningScore(punter uint64) int64 {
+	return g.connectivity.scores[punter]
+}
+
+// ReachesMine reports whether site is a mine, or punter holds a path of
+// rivers from it to a mine. TrackScore must have been called.
+func (g *Graph) ReachesMine(punter uint64, site protocol.SiteID) bool {
+	c := g.connectivity
+	i, ok := c.index[site]
+	if !ok {
+		return false
+	}
+
+	d, ok := c.punters[punter]
+	if !ok {
+		for _, mine := range c.mines {
+			if mine == site {
+				return true
+			}
+		}
+		return false
+	}
+	return len(d.mines[d.find(i)]) > 0
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Unreachable is the distance to sites that can't be reached.
+const Unreachable = -1
+
+// Distances returns the number of rivers on the shortest route from site
+// from to every site, indexed by site, or Unreachable. Ownership is ignored.
+func (c *Compact) Distances(from int32) []int32 {
+	dist := make([]int32, len(c.Sites))
+	for i := range dist {
+		dist[i] = Unreachable
+	}
+
+	// Every river has the same length, so a breadth-first search finds
+	// shortest routes without Dijkstra's priority queue.
+	queue := make([]int32, 0, len(c.Sites))
+	queue = append(queue, from)
+	dist[from] = 0
+
+	for head := 0; head < len(queue); head++ {
+		site := queue[head]
+		d := dist[site] + 1
+
+		for _, next := range c.Adj[c.Offsets[site]:c.Offsets[site+1]] {
+			if dist[next] == Unreachable {
+				dist[next] = d
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return dist
+}
+
+// MineDistances returns Distances from each of c.Mines, in the same order.
+// Mines are searched in parallel.
+func (c *Compact) MineDistances() [][]int32 {
+	result := make([][]int32, len(c.Mines))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(c.Mines) {
+		workers = len(c.Mines)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				result[i] = c.Distances(c.Mines[i])
+			}
+		}()
+	}
+
+	for i := range c.Mines {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return result
+}
@@ -0,0 +1,169 @@
+package graph_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+const mapDir = "../../maps"
+
+// sampleMaps are small maps on which the distance methods are compared.
+var sampleMaps = []string{
+	"sample.json",
+	"lambda.json",
+	"circle.json",
+	"Sierpinski-triangle.json",
+	"randomMedium.json",
+	"tube.json",
+}
+
+func readMap(path string) (*protocol.Map, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func loadMap(tb testing.TB, name string) *protocol.Map {
+	m, err := readMap(filepath.Join(mapDir, name))
+	if err != nil {
+		tb.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+func unitWeight(*graph.MetadataEdge) float64 {
+	return 1.0
+}
+
+// newGraph returns the Graph of m. gonum refuses some maps, such as those
+// with rivers from a site to itself, so ok is false for those.
+func newGraph(m *protocol.Map) (g *graph.Graph, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return graph.New(m, unitWeight), true
+}
+
+// dijkstra is how ShortestDistances used to work.
+func dijkstra(g *graph.Graph, m *protocol.Map) graph.Distances {
+	sites := g.Nodes()
+	dist := make(graph.Distances, len(m.Mines))
+	for _, mine := range m.Mines {
+		shortest := g.ShortestFrom(mine)
+
+		dist[mine] = make(map[protocol.SiteID]uint64, len(sites))
+		for _, site := range sites {
+			if w := shortest.WeightTo(site); !math.IsInf(w, 1) {
+				dist[mine][protocol.SiteID(site.ID())] = uint64(w)
+			}
+		}
+	}
+	return dist
+}
+
+func TestMineDistancesMatchDijkstra(t *testing.T) {
+	for _, name := range sampleMaps {
+		m := loadMap(t, name)
+		g, ok := newGraph(m)
+		if !ok {
+			t.Logf("%s: skipped, gonum refuses the map", name)
+			continue
+		}
+		want := dijkstra(g, m)
+
+		c := graph.NewCompact(m)
+		for i, row := range c.MineDistances() {
+			mine := c.Sites[c.Mines[i]]
+			for site, d := range row {
+				w, reachable := want[mine][c.Sites[site]]
+				switch {
+				case d == graph.Unreachable && reachable:
+					t.Errorf("%s: %d -> %d unreachable, want %d", name, mine, c.Sites[site], w)
+				case d != graph.Unreachable && (!reachable || uint64(d) != w):
+					t.Errorf("%s: %d -> %d = %d, want %d (reachable %v)", name, mine, c.Sites[site], d, w, reachable)
+				}
+			}
+		}
+	}
+}
+
+// benchMaps runs f as a sub-benchmark on each map in the maps directory. Some
+// of the files there are not valid maps, which are skipped.
+func benchMaps(b *testing.B, f func(b *testing.B, m *protocol.Map)) {
+	paths, err := filepath.Glob(filepath.Join(mapDir, "*.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, path := range paths {
+		m, err := readMap(path)
+		if err != nil {
+			b.Logf("Skipping %s: %v", filepath.Base(path), err)
+			continue
+		}
+		b.Run(filepath.Base(path), func(b *testing.B) {
+			f(b, m)
+		})
+	}
+}
+
+func BenchmarkDijkstra(b *testing.B) {
+	benchMaps(b, func(b *testing.B, m *protocol.Map) {
+		g, ok := newGraph(m)
+		if !ok {
+			b.Skip("gonum refuses the map")
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			dijkstra(g, m)
+		}
+	})
+}
+
+func BenchmarkDistances(b *testing.B) {
+	benchMaps(b, func(b *testing.B, m *protocol.Map) {
+		c := graph.NewCompact(m)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, mine := range c.Mines {
+				c.Distances(mine)
+			}
+		}
+	})
+}
+
+func BenchmarkMineDistances(b *testing.B) {
+	benchMaps(b, func(b *testing.B, m *protocol.Map) {
+		c := graph.NewCompact(m)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.MineDistances()
+		}
+	})
+}
+
+func BenchmarkShortestDistances(b *testing.B) {
+	benchMaps(b, func(b *testing.B, m *protocol.Map) {
+		g, ok := newGraph(m)
+		if !ok {
+			b.Skip("gonum refuses the map")
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			g.ShortestDistances(m.Mines)
+		}
+	})
+}
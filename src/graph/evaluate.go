@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// SiteScore is what one site scores for a punter from one mine.
+type SiteScore struct {
+	Site     protocol.SiteID `json:"site"`
+	Distance int64           `json:"distance"`
+	Score    int64           `json:"score"`
+}
+
+// MineScore is what a punter scores from one mine: the square of the
+// distance to every site connected to it.
+type MineScore struct {
+	Mine  protocol.SiteID `json:"mine"`
+	Score int64           `json:"score"`
+	Sites []SiteScore     `json:"sites"`
+}
+
+// FutureScore is what a punter scores from one future: the cube of the
+// distance from the mine to the target if they are connected, or minus that
+// if they are not.
+type FutureScore struct {
+	Future   protocol.Future `json:"future"`
+	Distance int64           `json:"distance"`
+	Achieved bool            `json:"achieved"`
+	Score    int64           `json:"score"`
+}
+
+// ScoreBreakdown is a punter's score and how it was made up.
+type ScoreBreakdown struct {
+	Punter  uint64        `json:"punter"`
+	Score   int64         `json:"score"`
+	Mines   []MineScore   `json:"mines"`
+	Futures []FutureScore `json:"futures,omitempty"`
+}
+
+// Evaluate scores every punter by the official rules, for the ownership and
+// options recorded in the rivers of m. futures are ignored unless settings
+// enables them.
+//
+// Evaluate scores from scratch. During a game, the engine and replays keep
+// running scores with Graph.TrackScore instead, which agree with Evaluate.
+func Evaluate(m *protocol.Map, punters int, futures []protocol.PunterFutures, settings protocol.Settings) []ScoreBreakdown {
+	c := NewCompact(m)
+	dist := c.MineDistances()
+
+	mineIndex := make(map[protocol.SiteID]int, len(c.Mines))
+	for i, mine := range c.Mines {
+		mineIndex[c.Sites[mine]] = i
+	}
+
+	result := make([]ScoreBreakdown, punters)
+	for p := range result {
+		b := &result[p]
+		b.Punter = uint64(p)
+		b.Mines = make([]MineScore, len(c.Mines))
+
+		// Join the sites connected by the punter's rivers.
+		d := newDisjointSet(len(c.Sites))
+		for r := range c.Source {
+			if !c.HeldBy(int32(r), int32(p)) {
+				continue
+			}
+			if x, y := d.find(c.Source[r]), d.find(c.Target[r]); x != y {
+				d.union(x, y)
+			}
+		}
+
+		for i, mine := range c.Mines {
+			ms := &b.Mines[i]
+			ms.Mine = c.Sites[mine]
+
+			root := d.find(mine)
+			for site, dd := range dist[i] {
+				if int32(site) == mine || dd == Unreachable || d.find(int32(site)) != root {
+					continue
+				}
+
+				score := int64(dd) * int64(dd)
+				ms.Sites = append(ms.Sites, SiteScore{
+					Site:     c.Sites[site],
+					Distance: int64(dd),
+					Score:    score,
+				})
+				ms.Score += score
+			}
+			b.Score += ms.Score
+		}
+
+		if !settings.Futures {
+			continue
+		}
+		for _, pf := range futures {
+			if pf.Punter != b.Punter {
+				continue
+			}
+
+			for _, f := range pf.Futures {
+				fs := FutureScore{Future: f}
+
+				i, isMine := mineIndex[f.Source]
+				target, ok := c.Index[f.Target]
+				if isMine && ok && dist[i][target] != Unreachable {
+					fs.Distance = int64(dist[i][target])
+					fs.Achieved = d.find(c.Mines[i]) == d.find(target)
+				}
+
+				fs.Score = ScoreFuture(fs.Distance, fs.Achieved)
+
+				b.Futures = append(b.Futures, fs)
+				b.Score += fs.Score
+			}
+		}
+	}
+
+	return result
+}
+
+// ScoreFuture returns what a future whose target is distance rivers from its
+// mine scores: the cube of the distance if it was achieved, or minus that if
+// not.
+func ScoreFuture(distance int64, achieved bool) int64 {
+	score := distance * distance * distance
+	if !achieved {
+		return -score
+	}
+	return score
+}
+
+// Totals returns just the scores from breakdowns.
+func Totals(breakdowns []ScoreBreakdown) []protocol.Score {
+	scores := make([]protocol.Score, len(breakdowns))
+	for i, b := range breakdowns {
+		scores[i] = protocol.Score{Punter: b.Punter, Score: b.Score}
+	}
+	return scores
+}
@@ -0,0 +1,198 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+	"github.com/jemoster/icfp2017/src/replay"
+)
+
+// line is a map of sites 0 to n-1 in a line, with a mine at 0, and rivers
+// from each site to the next held as given: owners[i] owns the river from i
+// to i+1, or nobody if it is negative.
+func line(owners ...int) protocol.Map {
+	m := protocol.Map{Mines: []protocol.SiteID{0}}
+	for i := 0; i <= len(owners); i++ {
+		m.Sites = append(m.Sites, protocol.Site{ID: protocol.SiteID(i)})
+	}
+	for i, p := range owners {
+		r := protocol.River{Source: protocol.SiteID(i), Target: protocol.SiteID(i + 1)}
+		if p >= 0 {
+			r.IsOwned, r.OwnerPunter = true, uint64(p)
+		}
+		m.Rivers = append(m.Rivers, r)
+	}
+	return m
+}
+
+// withOption returns m with the river from site i to i+1 optioned by punter.
+func withOption(m protocol.Map, i int, punter uint64) protocol.Map {
+	m.Rivers = append([]protocol.River(nil), m.Rivers...)
+	m.Rivers[i].IsOptioned, m.Rivers[i].OptionPunter = true, punter
+	return m
+}
+
+// withIsland returns m with two more sites, a mine and one other, joined by a
+// river owned by punter, but not by any river to the rest of the map.
+func withIsland(m protocol.Map, punter uint64) protocol.Map {
+	n := protocol.SiteID(len(m.Sites))
+	m.Sites = append(append([]protocol.Site(nil), m.Sites...), protocol.Site{ID: n}, protocol.Site{ID: n + 1})
+	m.Mines = append(append([]protocol.SiteID(nil), m.Mines...), n)
+	m.Rivers = append(append([]protocol.River(nil), m.Rivers...), protocol.River{
+		Source: n, Target: n + 1, IsOwned: true, OwnerPunter: punter,
+	})
+	return m
+}
+
+func futures(punter uint64, fs ...protocol.Future) protocol.PunterFutures {
+	return protocol.PunterFutures{Punter: punter, Futures: fs}
+}
+
+var withFutures = protocol.Settings{Futures: true}
+
+var evaluateTests = []struct {
+	name     string
+	m        protocol.Map
+	punters  int
+	futures  []protocol.PunterFutures
+	settings protocol.Settings
+	want     []int64
+}{
+	{
+		name:    "unclaimed",
+		m:       line(-1, -1, -1),
+		punters: 2,
+		want:    []int64{0, 0},
+	},
+	{
+		name:    "claims",
+		m:       line(0, 0, 1),
+		punters: 2,
+		// Punter 1's river doesn't reach the mine.
+		want: []int64{1 + 4, 0},
+	},
+	{
+		name:    "options",
+		m:       withOption(withOption(line(0, 0, 1), 0, 1), 1, 1),
+		punters: 2,
+		want:    []int64{1 + 4, 1 + 4 + 9},
+	},
+	{
+		name:     "futures",
+		m:        line(0, 0, 0, 1),
+		punters:  2,
+		futures:  []protocol.PunterFutures{futures(0, protocol.Future{Source: 0, Target: 3}), futures(1, protocol.Future{Source: 0, Target: 2})},
+		settings: withFutures,
+		want:     []int64{1 + 4 + 9 + 27, -8},
+	},
+	{
+		name:     "optioned future",
+		m:        withOption(line(0, 1), 0, 1),
+		punters:  2,
+		futures:  []protocol.PunterFutures{futures(1, protocol.Future{Source: 0, Target: 2})},
+		settings: withFutures,
+		want:     []int64{1, 1 + 4 + 8},
+	},
+	{
+		name:    "futures disabled",
+		m:       line(0, 0, 0),
+		punters: 1,
+		futures: []protocol.PunterFutures{futures(0, protocol.Future{Source: 0, Target: 3})},
+		want:    []int64{1 + 4 + 9},
+	},
+	{
+		name:    "unreachable sites",
+		m:       withIsland(line(0, 0), 0),
+		punters: 1,
+		// The island's mine scores its own site, but nothing from the
+		// line, which it can't reach.
+		want: []int64{1 + 4 + 1},
+	},
+	{
+		name:     "unreachable future",
+		m:        withIsland(line(0, 0), 0),
+		punters:  1,
+		futures:  []protocol.PunterFutures{futures(0, protocol.Future{Source: 0, Target: 4})},
+		settings: withFutures,
+		// A target that can't be reached is zero rivers away.
+		want: []int64{1 + 4 + 1},
+	},
+}
+
+func TestEvaluate(t *testing.T) {
+	for _, tt := range evaluateTests {
+		got := graph.Evaluate(&tt.m, tt.punters, tt.futures, tt.settings)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d scores, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for p, b := range got {
+			if b.Score != tt.want[p] {
+				t.Errorf("%s: punter %d scored %d, want %d", tt.name, p, b.Score, tt.want[p])
+			}
+
+			// The breakdown must add up to the score.
+			var sum int64
+			for _, ms := range b.Mines {
+				sum += ms.Score
+			}
+			for _, fs := range b.Futures {
+				sum += fs.Score
+			}
+			if sum != b.Score {
+				t.Errorf("%s: punter %d's breakdown adds up to %d, not %d", tt.name, p, sum, b.Score)
+			}
+		}
+	}
+}
+
+// transcripts are server transcripts whose final scores Evaluate must
+// reproduce.
+var transcripts = []string{
+	"../../tools/replay/server/sample.txt",
+	"../../tools/replay/server/brownian-test.2.txt",
+}
+
+func TestEvaluateTranscripts(t *testing.T) {
+	for _, path := range transcripts {
+		tr, err := replay.ParseFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if tr.Stop == nil {
+			t.Errorf("%s: the game did not finish", path)
+			continue
+		}
+
+		it := tr.Iterate()
+		for it.Next() {
+		}
+		m := tr.Setup.Map
+		m.Rivers = it.Graph().SerializeRivers()
+
+		// Our own server reports everyone's futures; otherwise only
+		// ours are known, and other punters' scores can't be checked if
+		// futures were allowed.
+		pf := tr.Stop.Futures
+		known := func(p uint64) bool { return true }
+		if pf == nil && tr.Setup.Settings.Futures {
+			if tr.Ready != nil {
+				pf = []protocol.PunterFutures{futures(tr.Setup.Punter, tr.Ready.Futures...)}
+			}
+			known = func(p uint64) bool { return p == tr.Setup.Punter }
+		}
+
+		got := graph.Evaluate(&m, int(tr.Setup.Punters), pf, tr.Setup.Settings)
+		for _, want := range tr.Stop.Scores {
+			switch {
+			case want.Punter >= uint64(len(got)):
+				t.Errorf("%s: no punter %d", path, want.Punter)
+			case !known(want.Punter):
+			case got[want.Punter].Score != want.Score:
+				t.Errorf("%s: punter %d scored %d, want %d", path, want.Punter, got[want.Punter].Score, want.Score)
+			}
+		}
+	}
+}
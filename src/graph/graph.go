@@ -35,6 +35,15 @@ func (m MetadataEdge) Weight() float64 {
 	return m.W
 }
 
+// HeldBy returns true if punter owns the river or holds the option on it.
+// Either way, the river counts towards punter's connectivity.
+func (m *MetadataEdge) HeldBy(punter uint64) bool {
+	if m.IsOwned && m.OwnerPunter == punter {
+		return true
+	}
+	return m.IsOptioned && m.OptionPunter == punter
+}
+
 // WeightFunc returns the weight of an edge.
 type WeightFunc func(e *MetadataEdge) float64
 
@@ -43,6 +52,19 @@ type Graph struct {
 	*simple.UndirectedGraph
 
 	weight WeightFunc
+
+	// connectivity tracks the sites each punter has connected, as rivers
+	// are claimed.
+	connectivity *connectivity
+
+	// version counts calls to Update, to invalidate the cached
+	// Criticality of each punter.
+	version         int
+	critical        map[uint64]*Criticality
+	criticalVersion int
+
+	// undo holds a record of each move made by Apply and not yet undone.
+	undo []undoRecord
 }
 
 // BuildWithWeight returns a graph.Graph that represents m.
@@ -52,6 +74,7 @@ func New(m *protocol.Map, weight WeightFunc) *Graph {
 	g := &Graph{
 		UndirectedGraph: simple.NewUndirectedGraph(0.0, math.Inf(0)),
 		weight:          weight,
+		connectivity:    newConnectivity(m),
 	}
 
 	for _, si := range m.Sites {
@@ -68,9 +91,11 @@ func New(m *protocol.Map, weight WeightFunc) *Graph {
 		}
 		if r.IsOwned {
 			river.OwnerPunter = r.OwnerPunter
+			g.connectivity.join(r.OwnerPunter, r.Source, r.Target, nil)
 		}
 		if r.IsOptioned {
 			river.OptionPunter = r.OptionPunter
+			g.connectivity.join(r.OptionPunter, r.Source, r.Target, nil)
 		}
 		river.W = g.weight(river)
 		g.SetEdge(river)
@@ -103,49 +128,66 @@ func (g *Graph) SerializeRivers() []protocol.River {
 }
 
 // Update adds the effect of the passed moves to the graph.
+//
+// Update must not be called while there are moves to Undo.
 func (g *Graph) Update(m []protocol.Move) {
 	for i := range m {
-		move := m[i]
-		var claim bool // true for claim, false for option.
-		var route []protocol.SiteID
-		var punter uint64
-		switch {
-		case move.Claim != nil:
-			claim = true
-			route = []protocol.SiteID{move.Claim.Source, move.Claim.Target}
-			punter = move.Claim.Punter
-		case move.Splurge != nil:
-			claim = true
-			route = move.Splurge.Route
-			punter = move.Splurge.Punter
-		case move.Option != nil:
-			route = []protocol.SiteID{move.Option.Source, move.Option.Target}
-			punter = move.Option.Punter
-		}
+		g.update(m[i], nil)
+	}
+}
 
-		if len(route) < 2 {
+// update adds the effect of move to the graph. If rec is not nil, enough is
+// recorded in it to reverse the move.
+func (g *Graph) update(move protocol.Move, rec *undoRecord) {
+	g.version++
+
+	var claim bool // true for claim, false for option.
+	var route []protocol.SiteID
+	var punter uint64
+	switch {
+	case move.Claim != nil:
+		claim = true
+		route = []protocol.SiteID{move.Claim.Source, move.Claim.Target}
+		punter = move.Claim.Punter
+	case move.Splurge != nil:
+		claim = true
+		route = move.Splurge.Route
+		punter = move.Splurge.Punter
+	case move.Option != nil:
+		route = []protocol.SiteID{move.Option.Source, move.Option.Target}
+		punter = move.Option.Punter
+	}
+
+	var unions *[]unionRecord
+	if rec != nil {
+		unions = &rec.unions
+	}
+
+	for i := 0; i < len(route)-1; i++ {
+		source := route[i]
+		target := route[i+1]
+
+		e := g.EdgeBetween(g.Node(int64(source)), g.Node(int64(target)))
+		if e == nil {
+			glog.Warningf("Invalid river {%d, %d} in move %v", source, target, move)
 			continue
 		}
+		edge := e.(*MetadataEdge)
+		if rec != nil {
+			rec.edges = append(rec.edges, edgeState{edge, *edge})
+		}
 
-		for i := 0; i < len(route)-1; i++ {
-			source := route[i]
-			target := route[i+1]
-
-			e := g.EdgeBetween(g.Node(int64(source)), g.Node(int64(target)))
-			if e == nil {
-				glog.Warningf("Invalid river {%d, %d} in move %v", source, target, move)
-				continue
-			}
-			edge := e.(*MetadataEdge)
-			if claim {
-				edge.IsOwned = true
-				edge.OwnerPunter = punter
-			} else {
-				edge.IsOptioned = true
-				edge.OptionPunter = punter
-			}
-			edge.W = g.weight(edge)
+		// A splurge through a river owned by someone else
+		// uses an option.
+		if claim && !(edge.IsOwned && edge.OwnerPunter != punter) {
+			edge.IsOwned = true
+			edge.OwnerPunter = punter
+		} else {
+			edge.IsOptioned = true
+			edge.OptionPunter = punter
 		}
+		edge.W = g.weight(edge)
+		g.connectivity.join(punter, source, target, unions)
 	}
 }
 
@@ -157,21 +199,24 @@ func (g *Graph) ShortestFrom(mine protocol.SiteID) path.Shortest {
 // Distances is a map from source mine ID to map of target site ID to distance.
 type Distances map[protocol.SiteID]map[protocol.SiteID]uint64
 
-// ShortestDistances returns the distances from each mine to every site.
+// ShortestDistances returns the number of rivers between each mine and every
+// site it can reach. Edge weights are ignored.
 //
-// g is a graph created by Build.
+// Map lookups are slow on large maps; code that looks up many distances
+// should use Compact.MineDistances instead.
 func (g *Graph) ShortestDistances(mines []protocol.SiteID) Distances {
-	sites := g.Nodes()
+	c := g.Compact(mines)
+	dist := c.MineDistances()
+
 	results := make(Distances, len(mines))
-	for _, mine := range mines {
-		shortest := g.ShortestFrom(mine)
-
-		results[mine] = make(map[protocol.SiteID]uint64, len(sites))
-		for _, site := range sites {
-			// All edges are weight 1, so the weight to a node is
-			// the distance to that node.
-			results[mine][protocol.SiteID(site.ID())] = uint64(shortest.WeightTo(g.Node(site.ID())))
+	for i, mine := range c.Mines {
+		m := make(map[protocol.SiteID]uint64, len(c.Sites))
+		for site, d := range dist[i] {
+			if d != Unreachable {
+				m[c.Sites[site]] = uint64(d)
+			}
 		}
+		results[c.Sites[mine]] = m
 	}
 
 	return results
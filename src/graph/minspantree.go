@@ -1 +1,234 @@
 package graph
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Tree is a set of rivers connecting some sites.
+type Tree struct {
+	Rivers []*MetadataEdge
+
+	// Cost is the total weight of Rivers.
+	Cost float64
+
+	// Unreachable are the sites that could not be connected, because every
+	// route to them has infinite weight.
+	Unreachable []protocol.SiteID
+}
+
+// Unclaimed returns the rivers in t that nobody owns yet: those still to be
+// claimed to complete the tree.
+func (t *Tree) Unclaimed() []*MetadataEdge {
+	var rivers []*MetadataEdge
+	for _, e := range t.Rivers {
+		if !e.IsOwned {
+			rivers = append(rivers, e)
+		}
+	}
+	return rivers
+}
+
+// SteinerTree returns a cheap tree of rivers connecting terminals, such as
+// a punter's mines and future targets, under the graph's WeightFunc.
+//
+// With a WeightFunc that makes the punter's own rivers free and other
+// punters' rivers infinite, the tree extends the punter's existing network
+// and Unclaimed gives the rivers to claim next.
+//
+// Finding the cheapest tree is NP-hard, so this grows the tree from the
+// first terminal by repeatedly adding the shortest path to the nearest
+// terminal not yet connected. The result costs at most twice the optimum.
+//
+// It returns an error if a terminal is not a site of the map.
+func (g *Graph) SteinerTree(terminals []protocol.SiteID) (*Tree, error) {
+	t := new(Tree)
+
+	inTree := make(map[int64]bool)
+	remaining := make(map[int64]bool)
+	for _, s := range terminals {
+		id := int64(s)
+		switch {
+		case g.Node(id) == nil:
+			return nil, fmt.Errorf("unknown site %d", s)
+		case len(inTree) == 0:
+			inTree[id] = true
+		case !inTree[id]:
+			remaining[id] = true
+		}
+	}
+
+	for len(remaining) > 0 {
+		target, _, prev := g.nearest(inTree, remaining, edgeWeight)
+		if prev == nil {
+			break
+		}
+
+		for id := target; !inTree[id]; id = prev[id].from {
+			e := prev[id].edge
+			t.Rivers = append(t.Rivers, e)
+			t.Cost += e.W
+			inTree[id] = true
+		}
+		delete(remaining, target)
+	}
+
+	for id := range remaining {
+		t.Unreachable = append(t.Unreachable, protocol.SiteID(id))
+	}
+	sort.Sort(bySiteID(t.Unreachable))
+
+	return t, nil
+}
+
+// step is how a shortest path reached a site.
+type step struct {
+	edge *MetadataEdge
+	from int64
+}
+
+// nearest finds the closest site in targets to any site in sources, under
+// cost. Rivers of infinite cost are not used. It returns the target, its
+// distance and the steps by which each site was reached, or a nil map if no
+// target can be reached.
+func (g *Graph) nearest(sources, targets map[int64]bool, cost WeightFunc) (int64, float64, map[int64]step) {
+	dist := make(map[int64]float64)
+	prev := make(map[int64]step)
+
+	q := &siteQueue{}
+	for id := range sources {
+		dist[id] = 0
+		heap.Push(q, queuedSite{id, 0})
+	}
+
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(queuedSite)
+		if cur.dist > dist[cur.id] {
+			// A stale entry; the site was reached more cheaply.
+			continue
+		}
+		if targets[cur.id] {
+			return cur.id, cur.dist, prev
+		}
+
+		node := g.Node(cur.id)
+		for _, n := range g.From(node) {
+			e := g.EdgeBetween(node, n).(*MetadataEdge)
+			c := cost(e)
+			if math.IsInf(c, 1) {
+				continue
+			}
+
+			next := n.ID()
+			d := cur.dist + c
+			if old, ok := dist[next]; ok && old <= d {
+				continue
+			}
+			dist[next] = d
+			prev[next] = step{e, cur.id}
+			heap.Push(q, queuedSite{next, d})
+		}
+	}
+
+	return 0, 0, nil
+}
+
+// edgeWeight is the cost of a river under the graph's WeightFunc.
+func edgeWeight(e *MetadataEdge) float64 {
+	return e.W
+}
+
+// Route returns the cheapest route from site from to site to under cost, and
+// what it costs. Unlike the graph's WeightFunc, cost may be changed between
+// searches. Rivers of infinite cost are not used. It returns a nil route if
+// to can't be reached.
+func (g *Graph) Route(from, to protocol.SiteID, cost WeightFunc) ([]protocol.SiteID, float64) {
+	if g.Node(int64(from)) == nil || g.Node(int64(to)) == nil {
+		return nil, 0
+	}
+
+	target, d, prev := g.nearest(map[int64]bool{int64(from): true}, map[int64]bool{int64(to): true}, cost)
+	if prev == nil {
+		return nil, 0
+	}
+
+	var route []protocol.SiteID
+	for id := target; id != int64(from); id = prev[id].from {
+		route = append(route, protocol.SiteID(id))
+	}
+	route = append(route, from)
+	for i, j := 0, len(route)-1; i < j; i, j = i+1, j-1 {
+		route[i], route[j] = route[j], route[i]
+	}
+	return route, d
+}
+
+type queuedSite struct {
+	id   int64
+	dist float64
+}
+
+// siteQueue is a min-heap of sites by distance.
+type siteQueue []queuedSite
+
+func (q siteQueue) Len() int            { return len(q) }
+func (q siteQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q siteQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *siteQueue) Push(x interface{}) { *q = append(*q, x.(queuedSite)) }
+func (q *siteQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+type byWeight []*MetadataEdge
+
+func (s byWeight) Len() int           { return len(s) }
+func (s byWeight) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byWeight) Less(i, j int) bool { return s[i].W < s[j].W }
+
+// MinSpanningTree returns a minimum spanning forest of the graph under its
+// WeightFunc. Rivers with infinite weight are left out, so the result has a
+// tree for each part of the map that can still be connected.
+//
+// It returns an error if a river joins a site that is not a site of the map.
+func (g *Graph) MinSpanningTree() (*Tree, error) {
+	var edges []*MetadataEdge
+	for _, e := range g.Edges() {
+		if me := e.(*MetadataEdge); !math.IsInf(me.W, 1) {
+			edges = append(edges, me)
+		}
+	}
+	sort.Stable(byWeight(edges))
+
+	// Kruskal's algorithm.
+	index := g.connectivity.index
+	d := newDisjointSet(len(index))
+	t := new(Tree)
+	for _, e := range edges {
+		ia, ok := index[protocol.SiteID(e.F.ID())]
+		if !ok {
+			return nil, fmt.Errorf("unknown site %d", e.F.ID())
+		}
+		ib, ok := index[protocol.SiteID(e.T.ID())]
+		if !ok {
+			return nil, fmt.Errorf("unknown site %d", e.T.ID())
+		}
+
+		a, b := d.find(ia), d.find(ib)
+		if a == b {
+			continue
+		}
+		d.union(a, b)
+
+		t.Rivers = append(t.Rivers, e)
+		t.Cost += e.W
+	}
+
+	return t, nil
+}
@@ -0,0 +1,265 @@
+package graph_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// riverWeight gives each river a weight from 1 to 5 that depends only on its
+// ends.
+func riverWeight(e *graph.MetadataEdge) float64 {
+	a, b := e.F.ID(), e.T.ID()
+	if a > b {
+		a, b = b, a
+	}
+	return float64((a*7+b*13)%5 + 1)
+}
+
+// randomMap returns a map of n sites and m distinct rivers between them.
+func randomMap(rng *rand.Rand, n, m int) protocol.Map {
+	var pairs [][2]protocol.SiteID
+	seen := make(map[[2]protocol.SiteID]bool)
+	for len(pairs) < m {
+		a, b := protocol.SiteID(rng.Intn(n)), protocol.SiteID(rng.Intn(n))
+		if a > b {
+			a, b = b, a
+		}
+		if a == b || seen[[2]protocol.SiteID{a, b}] {
+			continue
+		}
+		seen[[2]protocol.SiteID{a, b}] = true
+		pairs = append(pairs, [2]protocol.SiteID{a, b})
+	}
+	mp := rivers(pairs...)
+	// Every site, even those no river reaches.
+	mp.Sites = nil
+	for i := 0; i < n; i++ {
+		mp.Sites = append(mp.Sites, protocol.Site{ID: protocol.SiteID(i)})
+	}
+	return mp
+}
+
+// components returns the component of each site when joined by edges.
+func components(sites []protocol.Site, edges []*graph.MetadataEdge) map[protocol.SiteID]protocol.SiteID {
+	parent := make(map[protocol.SiteID]protocol.SiteID)
+	for _, s := range sites {
+		parent[s.ID] = s.ID
+	}
+	var find func(s protocol.SiteID) protocol.SiteID
+	find = func(s protocol.SiteID) protocol.SiteID {
+		if parent[s] != s {
+			parent[s] = find(parent[s])
+		}
+		return parent[s]
+	}
+	for _, e := range edges {
+		parent[find(protocol.SiteID(e.F.ID()))] = find(protocol.SiteID(e.T.ID()))
+	}
+
+	c := make(map[protocol.SiteID]protocol.SiteID, len(sites))
+	for _, s := range sites {
+		c[s.ID] = find(s.ID)
+	}
+	return c
+}
+
+// cheapest returns the cost of the cheapest set of finite rivers of g for
+// which connects returns true.
+func cheapest(g *graph.Graph, connects func(edges []*graph.MetadataEdge) bool) float64 {
+	var edges []*graph.MetadataEdge
+	for _, e := range g.Edges() {
+		if me := e.(*graph.MetadataEdge); !math.IsInf(me.W, 1) {
+			edges = append(edges, me)
+		}
+	}
+
+	best := math.Inf(1)
+	for set := 0; set < 1<<uint(len(edges)); set++ {
+		var sub []*graph.MetadataEdge
+		var cost float64
+		for i, e := range edges {
+			if set&(1<<uint(i)) != 0 {
+				sub = append(sub, e)
+				cost += e.W
+			}
+		}
+		if cost < best && connects(sub) {
+			best = cost
+		}
+	}
+	return best
+}
+
+func treeCost(t *graph.Tree) float64 {
+	var cost float64
+	for _, e := range t.Rivers {
+		cost += e.W
+	}
+	return cost
+}
+
+func TestMinSpanningTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		m := randomMap(rng, 6, 2+rng.Intn(8))
+		g := graph.New(&m, riverWeight)
+
+		tree, err := g.MinSpanningTree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := treeCost(tree); c != tree.Cost {
+			t.Errorf("%v: rivers cost %v, Cost says %v", m.Rivers, c, tree.Cost)
+		}
+
+		var edges []*graph.MetadataEdge
+		for _, e := range g.Edges() {
+			edges = append(edges, e.(*graph.MetadataEdge))
+		}
+		all := components(m.Sites, edges)
+		spans := func(sub []*graph.MetadataEdge) bool {
+			c := components(m.Sites, sub)
+			for a := range all {
+				for b := range all {
+					if (all[a] == all[b]) != (c[a] == c[b]) {
+						return false
+					}
+				}
+			}
+			return true
+		}
+		if !spans(tree.Rivers) {
+			t.Errorf("%v: tree %v does not span the map", m.Rivers, tree.Rivers)
+		}
+		if want := cheapest(g, spans); tree.Cost != want {
+			t.Errorf("%v: tree costs %v, the cheapest %v", m.Rivers, tree.Cost, want)
+		}
+	}
+}
+
+func TestSteinerTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		m := randomMap(rng, 7, 4+rng.Intn(7))
+		g := graph.New(&m, riverWeight)
+
+		var terminals []protocol.SiteID
+		for _, s := range m.Sites {
+			if rng.Intn(2) == 0 {
+				terminals = append(terminals, s.ID)
+			}
+		}
+		if len(terminals) == 0 {
+			continue
+		}
+
+		tree, err := g.SteinerTree(terminals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := treeCost(tree); c != tree.Cost {
+			t.Errorf("%v: rivers cost %v, Cost says %v", m.Rivers, c, tree.Cost)
+		}
+
+		// Only the terminals reachable from the first can be connected.
+		unreachable := make(map[protocol.SiteID]bool)
+		for _, s := range tree.Unreachable {
+			unreachable[s] = true
+		}
+		connects := func(sub []*graph.MetadataEdge) bool {
+			c := components(m.Sites, sub)
+			for _, s := range terminals {
+				if !unreachable[s] && c[s] != c[terminals[0]] {
+					return false
+				}
+			}
+			return true
+		}
+		if !connects(tree.Rivers) {
+			t.Errorf("%v: tree %v does not connect %v", m.Rivers, tree.Rivers, terminals)
+		}
+
+		var edges []*graph.MetadataEdge
+		for _, e := range g.Edges() {
+			edges = append(edges, e.(*graph.MetadataEdge))
+		}
+		all := components(m.Sites, edges)
+		for _, s := range terminals {
+			if unreachable[s] == (all[s] == all[terminals[0]]) {
+				t.Errorf("%v: %d reported unreachable %v", m.Rivers, s, unreachable[s])
+			}
+		}
+
+		want := cheapest(g, connects)
+		if tree.Cost < want || tree.Cost > 2*want {
+			t.Errorf("%v: tree for %v costs %v, the cheapest %v", m.Rivers, terminals, tree.Cost, want)
+		}
+	}
+}
+
+// ownership makes punter 0's rivers free and other punters' impassable.
+func ownership(e *graph.MetadataEdge) float64 {
+	switch {
+	case !e.IsOwned:
+		return 1
+	case e.OwnerPunter == 0:
+		return 0
+	}
+	return math.Inf(1)
+}
+
+func TestTreesOwnedRiversFree(t *testing.T) {
+	// A cycle of six sites, where punter 0 owns 0-1 and 1-2, and punter 1
+	// owns 3-4.
+	m := rivers(
+		[2]protocol.SiteID{0, 1}, [2]protocol.SiteID{1, 2}, [2]protocol.SiteID{2, 3},
+		[2]protocol.SiteID{3, 4}, [2]protocol.SiteID{4, 5}, [2]protocol.SiteID{5, 0},
+	)
+	m = held(held(held(m, 0, 1, 0, -1), 1, 2, 0, -1), 3, 4, 1, -1)
+	g := graph.New(&m, ownership)
+
+	tree, err := g.SteinerTree([]protocol.SiteID{0, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0-5-4 is cheapest; the owned rivers lead only to 3-4, which is
+	// punter 1's.
+	if tree.Cost != 2 || len(tree.Unclaimed()) != 2 {
+		t.Errorf("tree from 0 to 4 costs %v and claims %d rivers, want 2 and 2", tree.Cost, len(tree.Unclaimed()))
+	}
+
+	tree, err = g.SteinerTree([]protocol.SiteID{0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Along the owned rivers, only 2-3 must be claimed.
+	if tree.Cost != 1 || len(tree.Unclaimed()) != 1 || len(tree.Rivers) != 3 {
+		t.Errorf("tree from 0 to 3 costs %v with %d rivers, %d unclaimed; want 1, 3 and 1", tree.Cost, len(tree.Rivers), len(tree.Unclaimed()))
+	}
+
+	mst, err := g.MinSpanningTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned := 0
+	for _, e := range mst.Rivers {
+		switch {
+		case e.IsOwned && e.OwnerPunter != 0:
+			t.Errorf("spanning tree uses punter 1's river %v", e)
+		case e.IsOwned:
+			owned++
+		}
+	}
+	// Every other river is needed to span the six sites.
+	if owned != 2 || mst.Cost != 3 || len(mst.Rivers) != 5 {
+		t.Errorf("spanning tree has %d rivers, %d owned, costing %v; want 5, 2 and 3", len(mst.Rivers), owned, mst.Cost)
+	}
+
+	if _, err := g.SteinerTree([]protocol.SiteID{0, 42}); err == nil {
+		t.Error("no error for an unknown terminal")
+	}
+}
@@ -0,0 +1,89 @@
+package graph
+
+import (
+	"math"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// edgeState is an edge as it was before a move.
+type edgeState struct {
+	edge  *MetadataEdge
+	saved MetadataEdge
+}
+
+// undoRecord is enough to reverse one move made by Apply.
+type undoRecord struct {
+	edges  []edgeState
+	unions []unionRecord
+}
+
+// Apply makes move, as Update does, and records how to reverse it with Undo.
+//
+// Search code can explore hypothetical moves with Apply and Undo rather than
+// copying the graph. While there are moves to undo, connectivity queries
+// take O(log n) rather than near constant time.
+func (g *Graph) Apply(move protocol.Move) {
+	if len(g.undo) == 0 {
+		g.connectivity.setUndoable(true)
+	}
+
+	// Reuse the slices of records that have been undone.
+	var rec undoRecord
+	if len(g.undo) < cap(g.undo) {
+		rec = g.undo[:len(g.undo)+1][len(g.undo)]
+		rec.edges = rec.edges[:0]
+		rec.unions = rec.unions[:0]
+	}
+
+	g.update(move, &rec)
+	g.undo = append(g.undo, rec)
+}
+
+// Undo reverses the most recent move made by Apply that hasn't been undone,
+// restoring ownership, weights, connectivity and scores. It returns false if
+// there is nothing to undo.
+func (g *Graph) Undo() bool {
+	if len(g.undo) == 0 {
+		return false
+	}
+
+	rec := g.undo[len(g.undo)-1]
+	g.undo = g.undo[:len(g.undo)-1]
+
+	for i := len(rec.unions) - 1; i >= 0; i-- {
+		g.connectivity.split(rec.unions[i])
+	}
+	for i := len(rec.edges) - 1; i >= 0; i-- {
+		*rec.edges[i].edge = rec.edges[i].saved
+	}
+	g.version++
+
+	if len(g.undo) == 0 {
+		g.connectivity.setUndoable(false)
+	}
+	return true
+}
+
+// Clone returns a deep copy of g, which can be changed without affecting g.
+// The copy has nothing to undo.
+func (g *Graph) Clone() *Graph {
+	c := &Graph{
+		UndirectedGraph: simple.NewUndirectedGraph(0.0, math.Inf(0)),
+		weight:          g.weight,
+		connectivity:    g.connectivity.clone(),
+	}
+
+	for _, n := range g.Nodes() {
+		c.AddNode(simple.Node(n.ID()))
+	}
+	for _, e := range g.Edges() {
+		edge := *e.(*MetadataEdge)
+		edge.F = c.Node(edge.F.ID())
+		edge.T = c.Node(edge.T.ID())
+		c.SetEdge(&edge)
+	}
+
+	return c
+}
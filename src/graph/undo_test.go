@@ -0,0 +1,226 @@
+package graph_test
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+const undoPunters = 3
+
+// ownedWeight makes weights depend on ownership, so that Undo must restore
+// them too.
+func ownedWeight(e *graph.MetadataEdge) float64 {
+	switch {
+	case e.IsOwned:
+		return 0
+	case e.IsOptioned:
+		return 0.5
+	}
+	return 1
+}
+
+func points(punter uint64, mine, site protocol.SiteID) int64 {
+	return int64(mine)*1000 + int64(site) + 1
+}
+
+func trackedGraph(m *protocol.Map) *graph.Graph {
+	g := graph.New(m, ownedWeight)
+	g.TrackScore(m.Mines, points)
+	return g
+}
+
+// river is a river's ownership and weight.
+type river struct {
+	IsOwned, IsOptioned       bool
+	OwnerPunter, OptionPunter uint64
+	W                         float64
+}
+
+// snapshot is everything Undo must restore.
+type snapshot struct {
+	Rivers    map[[2]protocol.SiteID]river
+	Connected map[uint64][][2]protocol.SiteID
+	Reaches   map[uint64][]protocol.SiteID
+	Scores    []int64
+}
+
+func snap(g *graph.Graph, m *protocol.Map) snapshot {
+	s := snapshot{
+		Rivers:    make(map[[2]protocol.SiteID]river),
+		Connected: make(map[uint64][][2]protocol.SiteID),
+		Reaches:   make(map[uint64][]protocol.SiteID),
+	}
+	for _, e := range g.Edges() {
+		edge := e.(*graph.MetadataEdge)
+		a, b := protocol.SiteID(edge.F.ID()), protocol.SiteID(edge.T.ID())
+		if a > b {
+			a, b = b, a
+		}
+		s.Rivers[[2]protocol.SiteID{a, b}] = river{
+			IsOwned:      edge.IsOwned,
+			IsOptioned:   edge.IsOptioned,
+			OwnerPunter:  edge.OwnerPunter,
+			OptionPunter: edge.OptionPunter,
+			W:            edge.W,
+		}
+	}
+	for p := uint64(0); p < undoPunters; p++ {
+		for i, a := range m.Sites {
+			if g.ReachesMine(p, a.ID) {
+				s.Reaches[p] = append(s.Reaches[p], a.ID)
+			}
+			for _, b := range m.Sites[i+1:] {
+				if g.Connected(p, a.ID, b.ID) {
+					s.Connected[p] = append(s.Connected[p], [2]protocol.SiteID{a.ID, b.ID})
+				}
+			}
+		}
+		s.Scores = append(s.Scores, g.RunningScore(p))
+	}
+	return s
+}
+
+func edge(g *graph.Graph, r protocol.River) *graph.MetadataEdge {
+	return g.EdgeBetween(g.Node(int64(r.Source)), g.Node(int64(r.Target))).(*graph.MetadataEdge)
+}
+
+// randomMove returns a claim, option or splurge by punter that is legal on g,
+// or false if it found none.
+func randomMove(rng *rand.Rand, g *graph.Graph, m *protocol.Map, punter uint64) (protocol.Move, bool) {
+	var free, optionable []protocol.River
+	for _, r := range m.Rivers {
+		e := edge(g, r)
+		switch {
+		case !e.IsOwned:
+			free = append(free, r)
+		case e.OwnerPunter != punter && !e.IsOptioned:
+			optionable = append(optionable, r)
+		}
+	}
+
+	switch rng.Intn(3) {
+	case 0:
+		if len(optionable) > 0 {
+			r := optionable[rng.Intn(len(optionable))]
+			return protocol.Move{Option: &protocol.Option{Punter: punter, Source: r.Source, Target: r.Target}}, true
+		}
+	case 1:
+		// Walk along free rivers, and optionable ones, from a free one.
+		if len(free) == 0 {
+			break
+		}
+		r := free[rng.Intn(len(free))]
+		route := []protocol.SiteID{r.Source, r.Target}
+		used := map[protocol.River]bool{r: true}
+		for len(route) < 4 {
+			var next []protocol.River
+			at := route[len(route)-1]
+			for _, r := range append(free, optionable...) {
+				if !used[r] && (r.Source == at || r.Target == at) {
+					next = append(next, r)
+				}
+			}
+			if len(next) == 0 {
+				break
+			}
+			r := next[rng.Intn(len(next))]
+			used[r] = true
+			if r.Source == at {
+				route = append(route, r.Target)
+			} else {
+				route = append(route, r.Source)
+			}
+		}
+		return protocol.Move{Splurge: &protocol.Splurge{Punter: punter, Route: route}}, true
+	}
+
+	if len(free) == 0 {
+		return protocol.Move{}, false
+	}
+	r := free[rng.Intn(len(free))]
+	return protocol.Move{Claim: &protocol.Claim{Punter: punter, Source: r.Source, Target: r.Target}}, true
+}
+
+// TestUndo applies random claims, options and splurges, checking the graph
+// against one updated without undo records, then undoes them one by one,
+// checking it against the snapshots taken on the way, and finally against a
+// fresh graph.
+func TestUndo(t *testing.T) {
+	for _, name := range []string{"sample.json", "lambda.json", "circle.json"} {
+		m := loadMap(t, name)
+		rng := rand.New(rand.NewSource(1))
+
+		g := trackedGraph(m)
+		want := trackedGraph(m)
+
+		var snaps []snapshot
+		var moves []protocol.Move
+		for i := 0; ; i++ {
+			move, ok := randomMove(rng, g, m, uint64(i%undoPunters))
+			if !ok {
+				break
+			}
+			snaps = append(snaps, snap(g, m))
+			moves = append(moves, move)
+
+			g.Apply(move)
+			want.Update([]protocol.Move{move})
+			if !reflect.DeepEqual(snap(g, m), snap(want, m)) {
+				t.Fatalf("%s: Apply(%v) differs from Update", name, move)
+			}
+		}
+		if len(moves) < len(m.Rivers)/2 {
+			t.Fatalf("%s: only %d moves made", name, len(moves))
+		}
+
+		for i := len(moves) - 1; i >= 0; i-- {
+			if !g.Undo() {
+				t.Fatalf("%s: nothing to undo after %d moves", name, i+1)
+			}
+			if !reflect.DeepEqual(snap(g, m), snaps[i]) {
+				t.Fatalf("%s: Undo of move %d, %v, did not restore the graph", name, i, moves[i])
+			}
+		}
+		if g.Undo() {
+			t.Errorf("%s: Undo with nothing to undo", name)
+		}
+		if !reflect.DeepEqual(snap(g, m), snap(trackedGraph(m), m)) {
+			t.Errorf("%s: undoing every move does not give a fresh graph", name)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := loadMap(t, "lambda.json")
+	rng := rand.New(rand.NewSource(1))
+
+	g := trackedGraph(m)
+	for i := 0; i < len(m.Rivers)/2; i++ {
+		move, _ := randomMove(rng, g, m, uint64(i%undoPunters))
+		g.Update([]protocol.Move{move})
+	}
+	before := snap(g, m)
+
+	c := g.Clone()
+	if !reflect.DeepEqual(snap(c, m), before) {
+		t.Fatal("Clone differs from the original")
+	}
+
+	for i := 0; ; i++ {
+		move, ok := randomMove(rng, c, m, uint64(i%undoPunters))
+		if !ok {
+			break
+		}
+		c.Apply(move)
+	}
+	if reflect.DeepEqual(snap(c, m), before) {
+		t.Fatal("Moves made on the clone had no effect")
+	}
+	if !reflect.DeepEqual(snap(g, m), before) {
+		t.Error("Moves made on the clone changed the original")
+	}
+}
@@ -0,0 +1,99 @@
+// Package moves helps bots make moves other than claims: options on rivers
+// that opponents own, and splurges paid for by passing.
+//
+// A Ledger, kept in the bot's state, counts each punter's splurge credit and
+// remaining options from the moves the server reports. Route and Propose
+// then plan a route that may use options, and the moves that advance along
+// it.
+package moves
+
+import (
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Ledger counts each punter's splurge credit and remaining options, as the
+// server counts them. It marshals to JSON, to be kept in the bot's state.
+type Ledger struct {
+	Punter uint64
+
+	// Credit is the number of rivers beyond the first that each punter
+	// may claim in a splurge, and Options the number of options each has
+	// left.
+	Credit  []int
+	Options []int
+
+	// Requests is the number of move requests seen.
+	Requests int
+}
+
+// NewLedger returns a Ledger for the game set up by setup.
+func NewLedger(setup *protocol.Setup) *Ledger {
+	l := &Ledger{
+		Punter:  setup.Punter,
+		Credit:  make([]int, setup.Punters),
+		Options: make([]int, setup.Punters),
+	}
+	if setup.Settings.Options {
+		for i := range l.Options {
+			l.Options[i] = len(setup.Map.Mines)
+		}
+	}
+	return l
+}
+
+// Update applies the moves of a move request to g, as Graph.Update does,
+// and counts the credit and options they earn and spend.
+//
+// Each move is applied in turn, so that a splurge is charged for the options
+// it buys on rivers owned by others when it was made.
+func (l *Ledger) Update(g *graph.Graph, m []protocol.Move) {
+	for _, move := range m {
+		p, ok := move.Punter()
+		if !ok || p >= uint64(len(l.Credit)) {
+			continue
+		}
+
+		switch {
+		case move.Pass != nil:
+			// In the first request, punters from us onwards have
+			// not moved yet, and are shown as passing.
+			if l.Requests > 0 || p < l.Punter {
+				l.Credit[p]++
+			}
+		case move.Option != nil:
+			l.Options[p]--
+		case move.Splurge != nil:
+			route := move.Splurge.Route
+			l.Credit[p] -= len(route) - 2
+			for i := 0; i < len(route)-1; i++ {
+				if e := river(g, route[i], route[i+1]); e != nil && e.IsOwned && e.OwnerPunter != p {
+					l.Options[p]--
+				}
+			}
+		}
+
+		g.Update([]protocol.Move{move})
+	}
+
+	l.Requests++
+}
+
+// MyCredit returns our splurge credit.
+func (l *Ledger) MyCredit() int {
+	return l.Credit[l.Punter]
+}
+
+// MyOptions returns the number of options we have left.
+func (l *Ledger) MyOptions() int {
+	return l.Options[l.Punter]
+}
+
+// river returns the river between a and b, or nil if there is none.
+func river(g *graph.Graph, a, b protocol.SiteID) *graph.MetadataEdge {
+	e := g.EdgeBetween(g.Node(int64(a)), g.Node(int64(b)))
+	if e == nil {
+		return nil
+	}
+	return e.(*graph.MetadataEdge)
+}
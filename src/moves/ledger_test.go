@@ -0,0 +1,168 @@
+package moves_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/engine"
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/moves"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+func loadMap(t *testing.T, name string) *protocol.Map {
+	b, err := ioutil.ReadFile("../../maps/" + name)
+	if err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	m := new(protocol.Map)
+	if err := json.Unmarshal(b, m); err != nil {
+		t.Fatalf("Failed to load %s: %v", name, err)
+	}
+	return m
+}
+
+func unitWeight(*graph.MetadataEdge) float64 {
+	return 1
+}
+
+// checkProposals fails t if any of moves spends more credit or options than
+// l has.
+func checkProposals(t *testing.T, l *moves.Ledger, g *graph.Graph, proposals []protocol.Move) {
+	for _, move := range proposals {
+		if move.Splurge == nil {
+			continue
+		}
+		route := move.Splurge.Route
+		if cost := len(route) - 2; cost > l.MyCredit() {
+			t.Errorf("Splurge %v costs %d with %d credit", route, cost, l.MyCredit())
+		}
+		options := 0
+		for i := 0; i < len(route)-1; i++ {
+			e := g.EdgeBetween(g.Node(int64(route[i])), g.Node(int64(route[i+1]))).(*graph.MetadataEdge)
+			if e.IsOwned {
+				options++
+			}
+		}
+		if options > l.MyOptions() {
+			t.Errorf("Splurge %v needs %d options with %d left", route, options, l.MyOptions())
+		}
+	}
+}
+
+// TestLedgerMatchesEngine plays random games, in which each punter keeps a
+// Ledger from the move requests the server would send, and checks that every
+// Ledger agrees with the engine on every punter's credit and options each
+// turn.
+func TestLedgerMatchesEngine(t *testing.T) {
+	settings := protocol.Settings{Options: true, Splurges: true}
+	const punters = 3
+
+	var splurges, options int
+	for _, name := range []string{"sample.json", "lambda.json", "circle.json"} {
+		m := loadMap(t, name)
+		rng := rand.New(rand.NewSource(1))
+		e := engine.New(m, punters, settings)
+
+		ledgers := make([]*moves.Ledger, punters)
+		graphs := make([]*graph.Graph, punters)
+		for p := range ledgers {
+			ledgers[p] = moves.NewLedger(&protocol.Setup{Punter: uint64(p), Punters: punters, Map: *m, Settings: settings})
+			graphs[p] = graph.New(m, unitWeight)
+		}
+
+		for !e.Done() {
+			p := e.Current()
+			l, g := ledgers[p], graphs[p]
+			l.Update(g, e.LastMoves())
+
+			for q := uint64(0); q < punters; q++ {
+				if l.Credit[q] != e.Credit(q) || l.Options[q] != e.Options(q) {
+					t.Fatalf("%s: turn %d: punter %d's ledger has credit %d and options %d for %d, the engine %d and %d",
+						name, e.Turn, p, l.Credit[q], l.Options[q], q, e.Credit(q), e.Options(q))
+				}
+			}
+
+			// Head for a random site from a random mine, splurging and
+			// buying options on the way, and otherwise pass often
+			// enough to earn credit.
+			var move protocol.Move
+			mine := m.Mines[rng.Intn(len(m.Mines))]
+			route, _ := moves.Route(g, settings, l, mine, m.Sites[rng.Intn(len(m.Sites))].ID)
+			proposals := moves.Propose(g, settings, l, route)
+			checkProposals(t, l, g, proposals)
+			switch {
+			case rng.Intn(3) == 0:
+				move = protocol.Move{Pass: &protocol.Pass{Punter: p}}
+			case len(proposals) > 0:
+				move = proposals[0]
+			default:
+				legal := e.LegalMoves(p)
+				move = legal[rng.Intn(len(legal))]
+			}
+
+			switch {
+			case move.Splurge != nil:
+				splurges++
+			case move.Option != nil:
+				options++
+			}
+			if err := e.ApplyMove(p, move); err != nil {
+				t.Fatalf("%s: turn %d: %v refused: %v", name, e.Turn, move, err)
+			}
+		}
+	}
+	if splurges == 0 || options == 0 {
+		t.Errorf("%d splurges and %d options made; the ledgers weren't tested on both", splurges, options)
+	}
+}
+
+// TestProposeCredit checks that a splurge along a long route never takes more
+// rivers than our credit pays for.
+func TestProposeCredit(t *testing.T) {
+	settings := protocol.Settings{Options: true, Splurges: true}
+
+	m := &protocol.Map{Mines: []protocol.SiteID{0}}
+	var route []protocol.SiteID
+	for i := 0; i < 10; i++ {
+		m.Sites = append(m.Sites, protocol.Site{ID: protocol.SiteID(i)})
+		route = append(route, protocol.SiteID(i))
+		if i > 0 {
+			m.Rivers = append(m.Rivers, protocol.River{Source: protocol.SiteID(i - 1), Target: protocol.SiteID(i)})
+		}
+	}
+	// Another punter owns a river part way along.
+	m.Rivers[4].IsOwned, m.Rivers[4].OwnerPunter = true, 1
+	g := graph.New(m, unitWeight)
+
+	for credit := 0; credit < 12; credit++ {
+		for opts := 0; opts < 2; opts++ {
+			l := moves.NewLedger(&protocol.Setup{Punter: 0, Punters: 2, Map: *m, Settings: settings})
+			l.Credit[0] = credit
+			l.Options[0] = opts
+
+			proposals := moves.Propose(g, settings, l, route)
+			if len(proposals) == 0 {
+				t.Fatalf("Credit %d, %d options: nothing proposed", credit, opts)
+			}
+			checkProposals(t, l, g, proposals)
+
+			want := credit + 1
+			if opts == 0 && want > 4 {
+				want = 4
+			}
+			if want > len(m.Rivers) {
+				want = len(m.Rivers)
+			}
+			got := 1
+			if s := proposals[0].Splurge; s != nil {
+				got = len(s.Route) - 1
+			}
+			if got != want {
+				t.Errorf("Credit %d, %d options: first move takes %d rivers, want %d", credit, opts, got, want)
+			}
+		}
+	}
+}
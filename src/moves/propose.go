@@ -0,0 +1,111 @@
+package moves
+
+import (
+	"math"
+
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// OptionCost is the length of a river that must be optioned, relative to one
+// that can be claimed. Options are scarce, so a route through an opponent's
+// river should save more than a single claim.
+const OptionCost = 2
+
+// cost returns the length of river e on our routes, or false if we can't use
+// it.
+func (l *Ledger) cost(e *graph.MetadataEdge, settings protocol.Settings) (float64, bool) {
+	switch {
+	case e.HeldBy(l.Punter):
+		return 0, true
+	case !e.IsOwned:
+		return 1, true
+	case settings.Options && l.MyOptions() > 0 && !e.IsOptioned:
+		return OptionCost, true
+	}
+	return 0, false
+}
+
+// Route returns our cheapest route from site from to site to, and its cost.
+// Rivers we hold are free, and rivers owned by others can be used if we can
+// buy an option on them, at OptionCost. It returns a nil route if to can't be
+// reached.
+func Route(g *graph.Graph, settings protocol.Settings, l *Ledger, from, to protocol.SiteID) ([]protocol.SiteID, float64) {
+	return g.Route(from, to, func(e *graph.MetadataEdge) float64 {
+		c, ok := l.cost(e, settings)
+		if !ok {
+			return math.Inf(1)
+		}
+		return c
+	})
+}
+
+// Propose returns the moves that advance us along route, best first.
+//
+// The first river along the route that we don't hold is claimed, or optioned
+// if someone else owns it. If we have the credit, a splurge comes first,
+// taking as many of the following rivers as we can afford at once, as long
+// as we don't already hold any of them. Propose returns no moves if we hold
+// the whole route, or the next river can't be used.
+func Propose(g *graph.Graph, settings protocol.Settings, l *Ledger, route []protocol.SiteID) []protocol.Move {
+	// Find the first river we need, and how far after it we could
+	// splurge.
+	first := -1
+	end := -1
+	options := 0
+	for i := 0; i < len(route)-1; i++ {
+		e := river(g, route[i], route[i+1])
+		if e == nil {
+			break
+		}
+		c, ok := l.cost(e, settings)
+		if !ok || c == 0 {
+			if first != -1 {
+				break
+			}
+			if !ok {
+				return nil
+			}
+			continue
+		}
+
+		if first == -1 {
+			first = i
+		}
+		if e.IsOwned {
+			options++
+		}
+		if i-first > l.MyCredit() || options > l.MyOptions() {
+			break
+		}
+		end = i + 1
+	}
+	if first == -1 {
+		return nil
+	}
+
+	var proposals []protocol.Move
+	if settings.Splurges && end-first > 1 {
+		proposals = append(proposals, protocol.Move{Splurge: &protocol.Splurge{
+			Punter: l.Punter,
+			Route:  append([]protocol.SiteID(nil), route[first:end+1]...),
+		}})
+	}
+
+	source, target := route[first], route[first+1]
+	if river(g, source, target).IsOwned {
+		proposals = append(proposals, protocol.Move{Option: &protocol.Option{
+			Punter: l.Punter,
+			Source: source,
+			Target: target,
+		}})
+	} else {
+		proposals = append(proposals, protocol.Move{Claim: &protocol.Claim{
+			Punter: l.Punter,
+			Source: source,
+			Target: target,
+		}})
+	}
+
+	return proposals
+}
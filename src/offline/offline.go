@@ -0,0 +1,194 @@
+// Package offline runs bots that speak the offline protocol, in which every
+// stage of the game is played by a new process and all state is carried
+// between processes by the caller.
+package offline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+	. "github.com/jemoster/icfp2017/src/protocol/io"
+)
+
+// ErrTimeout is returned when a bot fails to respond before the deadline.
+var ErrTimeout = errors.New("offline: bot timed out")
+
+// Bot is an executable that speaks the offline protocol.
+type Bot struct {
+	// Command is the executable followed by its arguments.
+	Command []string
+
+	// Name is the name given in the bot's most recent handshake.
+	Name string
+
+	// Stderr receives the bot's standard error. If nil, it is discarded.
+	Stderr io.Writer
+}
+
+// New returns a Bot that runs command, which is split on spaces.
+func New(command string) *Bot {
+	return &Bot{
+		Command: strings.Fields(command),
+		Stderr:  os.Stderr,
+	}
+}
+
+// Ready is the bot's response to setup.
+type Ready struct {
+	Ready   uint64            `json:"ready"`
+	Futures []protocol.Future `json:"futures,omitempty"`
+
+	// State is the bot's opaque state, to be passed to the next stage.
+	State json.RawMessage `json:"state"`
+}
+
+// Move is the bot's response to a gameplay stage.
+type Move struct {
+	protocol.Move
+
+	// State is the bot's opaque state, to be passed to the next stage.
+	State json.RawMessage `json:"state"`
+}
+
+type moveInput struct {
+	Move struct {
+		Moves []protocol.Move `json:"moves"`
+	} `json:"move"`
+	State json.RawMessage `json:"state"`
+}
+
+type stopInput struct {
+	Stop  *protocol.Stop  `json:"stop"`
+	State json.RawMessage `json:"state"`
+}
+
+// Handshake starts the bot only to learn its name, which is stored in Name.
+func (b *Bot) Handshake(timeout time.Duration) error {
+	return b.run(nil, nil, timeout)
+}
+
+// Setup runs the setup stage.
+func (b *Bot) Setup(s *protocol.Setup, timeout time.Duration) (*Ready, error) {
+	r := new(Ready)
+	if err := b.run(s, r, timeout); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Play runs a gameplay stage, passing the previous moves of every punter and
+// the state returned by the previous stage.
+func (b *Bot) Play(moves []protocol.Move, state json.RawMessage, timeout time.Duration) (*Move, error) {
+	in := moveInput{State: state}
+	in.Move.Moves = moves
+
+	m := new(Move)
+	if err := b.run(&in, m, timeout); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Stop runs the stop stage. The bot is not expected to respond.
+func (b *Bot) Stop(s *protocol.Stop, state json.RawMessage, timeout time.Duration) error {
+	return b.run(&stopInput{Stop: s, State: state}, nil, timeout)
+}
+
+// run starts a new bot process and performs the handshake. If in is non-nil
+// it is sent to the bot, and if out is non-nil the response is received into
+// out.
+func (b *Bot) run(in, out interface{}, timeout time.Duration) error {
+	if len(b.Command) == 0 {
+		return fmt.Errorf("no bot command")
+	}
+
+	cmd := exec.Command(b.Command[0], b.Command[1:]...)
+	cmd.Stderr = b.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %v: %v", b.Command, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.exchange(stdin, bufio.NewReader(stdout), in, out)
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = ErrTimeout
+	}
+
+	// A bot that has only handshaken is waiting for input it will never
+	// get.
+	if err != nil || in == nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	// The bot has said everything we need. Give it a moment to exit
+	// cleanly, but don't let it linger.
+	stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	return nil
+}
+
+func (b *Bot) exchange(w io.Writer, r *bufio.Reader, in, out interface{}) error {
+	var h protocol.HandshakeClientServer
+	if err := Recv(r, &h); err != nil {
+		return fmt.Errorf("failed receiving handshake: %v", err)
+	}
+	b.Name = h.Me
+
+	if err := Send(w, &protocol.HandshakeServerClient{You: h.Me}); err != nil {
+		return fmt.Errorf("failed sending handshake: %v", err)
+	}
+
+	if in == nil {
+		return nil
+	}
+
+	if err := Send(w, in); err != nil {
+		return fmt.Errorf("failed sending input: %v", err)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := Recv(r, out); err != nil {
+		return fmt.Errorf("failed receiving output: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package online plays a game on an online-mode server using a bot that
+// speaks the offline protocol, spawning the bot once per stage and carrying
+// its state between stages.
+package online
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jemoster/icfp2017/src/offline"
+	"github.com/jemoster/icfp2017/src/protocol"
+	. "github.com/jemoster/icfp2017/src/protocol/io"
+)
+
+// DefaultServer is the official server, whose lobby describes each game.
+const DefaultServer = "punter.inf.ed.ac.uk"
+
+// statusURL is the lobby of DefaultServer.
+var statusURL = "http://" + DefaultServer + "/status.json"
+
+// Metadata is the first line of a transcript, with the same fields as
+// tools/bot_runner/online_adapter.py writes.
+type Metadata struct {
+	Metadata int    `json:"metadata"`
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+
+	// Status is the game's entry in the official server's lobby, or empty
+	// for other servers or if the lobby couldn't be read.
+	Status interface{} `json:"status"`
+	Extra  interface{} `json:"extra,omitempty"`
+}
+
+// NewMetadata returns the Metadata of a game on port of server.
+func NewMetadata(server string, port int) *Metadata {
+	md := &Metadata{
+		Server: server,
+		Port:   port,
+		Status: map[string]interface{}{},
+	}
+	if server != DefaultServer {
+		return md
+	}
+
+	if status, err := lobbyStatus(port); err != nil {
+		glog.Warningf("Failed to read the status of port %d: %v", port, err)
+	} else if status != nil {
+		md.Status = status
+	}
+	return md
+}
+
+// lobbyStatus returns the entry for port in the official server's lobby, or
+// nil if there is none.
+func lobbyStatus(port int) (map[string]interface{}, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(statusURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", statusURL, resp.Status)
+	}
+
+	var lobby struct {
+		Games []map[string]interface{} `json:"games"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lobby); err != nil {
+		return nil, err
+	}
+	for _, game := range lobby.Games {
+		if p, ok := game["port"].(float64); ok && int(p) == port {
+			return game, nil
+		}
+	}
+	return nil, nil
+}
+
+// Adapter connects a Bot to a server.
+type Adapter struct {
+	Bot *offline.Bot
+
+	SetupTimeout time.Duration
+	MoveTimeout  time.Duration
+
+	// Record, if non-nil, receives a transcript of every message
+	// exchanged with the server: ">> " prefixes messages sent, "<< "
+	// messages received, one per line.
+	Record io.Writer
+
+	// Punter is our punter ID, valid once setup is received.
+	Punter uint64
+
+	state json.RawMessage
+}
+
+// readyMessage is protocol.Ready without the bot state, which stays local.
+type readyMessage struct {
+	Ready   uint64            `json:"ready"`
+	Futures []protocol.Future `json:"futures,omitempty"`
+}
+
+func (a *Adapter) record(prefix string, b []byte) {
+	if a.Record == nil {
+		return
+	}
+	fmt.Fprintf(a.Record, "%s %s\n", prefix, b)
+}
+
+func (a *Adapter) send(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed marshaling %+v: %v", v, err)
+	}
+
+	glog.V(1).Infof("Send(%T): %s", v, string(b))
+	a.record(">>", b)
+
+	return WriteMessage(w, b)
+}
+
+func (a *Adapter) recv(r *bufio.Reader, v interface{}) error {
+	b, err := ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("Recv(%T): %s", v, string(b))
+	a.record("<<", b)
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to unmarshal: %v, %s", err, string(b))
+	}
+	return nil
+}
+
+// Play plays a complete game over rw, returning the final scores.
+func (a *Adapter) Play(rw io.ReadWriter) ([]protocol.Score, error) {
+	r := bufio.NewReader(rw)
+
+	if err := a.Bot.Handshake(a.SetupTimeout); err != nil {
+		return nil, fmt.Errorf("failed to get bot name: %v", err)
+	}
+
+	if err := a.send(rw, &protocol.HandshakeClientServer{Me: a.Bot.Name}); err != nil {
+		return nil, fmt.Errorf("failed sending handshake: %v", err)
+	}
+
+	var hr protocol.HandshakeServerClient
+	if err := a.recv(r, &hr); err != nil {
+		return nil, fmt.Errorf("failed receiving handshake: %v", err)
+	}
+
+	var setup protocol.Setup
+	if err := a.recv(r, &setup); err != nil {
+		return nil, fmt.Errorf("failed receiving setup: %v", err)
+	}
+	a.Punter = setup.Punter
+
+	ready, err := a.Bot.Setup(&setup, a.SetupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("bot setup failed: %v", err)
+	}
+	a.state = ready.State
+
+	if err := a.send(rw, &readyMessage{Ready: ready.Ready, Futures: ready.Futures}); err != nil {
+		return nil, fmt.Errorf("failed sending ready: %v", err)
+	}
+
+	for {
+		var msg protocol.ServerMessage
+		if err := a.recv(r, &msg); err != nil {
+			return nil, fmt.Errorf("failed receiving gameplay message: %v", err)
+		}
+
+		switch {
+		case msg.Stop != nil:
+			if err := a.Bot.Stop(msg.Stop, a.state, a.MoveTimeout); err != nil {
+				glog.Warningf("Bot stop failed: %v", err)
+			}
+			return msg.Stop.Scores, nil
+		case msg.Timeout != nil:
+			glog.Warningf("Server reports we timed out after %vs", *msg.Timeout)
+		case msg.Move != nil:
+			move := protocol.Move{Pass: &protocol.Pass{Punter: a.Punter}}
+
+			out, err := a.Bot.Play(msg.Move.Moves, a.state, a.MoveTimeout)
+			if err != nil {
+				// Keep the old state; the bot may do
+				// better next turn.
+				glog.Warningf("Bot move failed, passing: %v", err)
+			} else {
+				move = out.Move
+				a.state = out.State
+			}
+
+			if err := a.send(rw, &move); err != nil {
+				return nil, fmt.Errorf("failed sending move: %v", err)
+			}
+		default:
+			glog.Warningf("Ignoring unknown message %+v", msg)
+		}
+	}
+}
+
+// Dial connects to the server at addr and plays a complete game.
+func (a *Adapter) Dial(addr string) ([]protocol.Score, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return a.Play(conn)
+}
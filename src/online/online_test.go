@@ -0,0 +1,52 @@
+package online
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestMetadata checks that transcripts start with the fields
+// online_adapter.py writes.
+func TestMetadata(t *testing.T) {
+	lobby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"games": [
+			{"port": 9001, "status": "Waiting for punters. (0/2)"},
+			{"port": 9002, "status": "Waiting for punters. (1/2)", "map_name": "sample.json"}
+		]}`)
+	}))
+	defer lobby.Close()
+	defer func(url string) { statusURL = url }(statusURL)
+	statusURL = lobby.URL
+
+	for _, tt := range []struct {
+		server string
+		port   int
+		want   string
+	}{
+		{DefaultServer, 9002, `{"metadata": 0, "server": "punter.inf.ed.ac.uk", "port": 9002,
+			"status": {"port": 9002, "status": "Waiting for punters. (1/2)", "map_name": "sample.json"}}`},
+		// Ports not in the lobby, and other servers, have no status.
+		{DefaultServer, 9003, `{"metadata": 0, "server": "punter.inf.ed.ac.uk", "port": 9003, "status": {}}`},
+		{"localhost", 9002, `{"metadata": 0, "server": "localhost", "port": 9002, "status": {}}`},
+	} {
+		b, err := json.Marshal(NewMetadata(tt.server, tt.port))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:%d: metadata %s, want %s", tt.server, tt.port, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// compactState is a Game's state as passed between stages by CompactGame:
+// gzipped JSON, with the map, if the state holds one, taken out.
+type compactState struct {
+	Data []byte `json:"compact"`
+
+	// Field is the top-level field of the state that held Map.
+	Field string      `json:"field,omitempty"`
+	Map   *compactMap `json:"map,omitempty"`
+}
+
+// compactMap stands in for a map: the map without the ownership of its
+// rivers, which never changes, and the ownership as bitsets. Every state
+// carries the whole map, so that any state can be decoded on its own.
+type compactMap struct {
+	// Data is the gzipped gob of the map.
+	Data []byte `json:"data"`
+
+	// Owned and Optioned have a bitset for each punter, with bit i set if
+	// the punter owns or holds the option on river i of the map.
+	Owned    [][]byte `json:"owned,omitempty"`
+	Optioned [][]byte `json:"optioned,omitempty"`
+}
+
+type riverEnds struct {
+	a, b SiteID
+}
+
+func ends(r River) riverEnds {
+	if r.Source < r.Target {
+		return riverEnds{r.Source, r.Target}
+	}
+	return riverEnds{r.Target, r.Source}
+}
+
+// packedMap is a map without ownership, with its encoding.
+type packedMap struct {
+	m    *Map
+	data []byte
+
+	// index gives the position of each river in m.
+	index map[riverEnds]int
+}
+
+func newPackedMap(m *Map, data []byte) *packedMap {
+	p := &packedMap{
+		m:     m,
+		data:  data,
+		index: make(map[riverEnds]int, len(m.Rivers)),
+	}
+	for i, r := range m.Rivers {
+		p.index[ends(r)] = i
+	}
+	return p
+}
+
+// packMap encodes m, without the ownership of its rivers.
+func packMap(m *Map) (*packedMap, error) {
+	s := &Map{
+		Sites:  m.Sites,
+		Rivers: make([]River, len(m.Rivers)),
+		Mines:  m.Mines,
+	}
+	for i, r := range m.Rivers {
+		s.Rivers[i] = River{Source: r.Source, Target: r.Target}
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(w).Encode(s); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return newPackedMap(s, buf.Bytes()), nil
+}
+
+// unpackMap decodes the map in cm.
+func unpackMap(cm *compactMap) (*packedMap, error) {
+	r, err := gzip.NewReader(bytes.NewReader(cm.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress map: %v", err)
+	}
+	m := new(Map)
+	if err := gob.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode map: %v", err)
+	}
+
+	return newPackedMap(m, cm.Data), nil
+}
+
+// compact returns the compactMap for p with the ownership of rivers, which
+// may be in any order. It returns false if rivers are not p's.
+func (p *packedMap) compact(rivers []River) (*compactMap, bool) {
+	if len(rivers) != len(p.m.Rivers) {
+		return nil, false
+	}
+
+	cm := &compactMap{Data: p.data}
+	set := func(sets *[][]byte, punter uint64, i int) {
+		for uint64(len(*sets)) <= punter {
+			*sets = append(*sets, make([]byte, (len(rivers)+7)/8))
+		}
+		(*sets)[punter][i/8] |= 1 << uint(i%8)
+	}
+	for _, r := range rivers {
+		i, ok := p.index[ends(r)]
+		if !ok {
+			return nil, false
+		}
+		if r.IsOwned {
+			set(&cm.Owned, r.OwnerPunter, i)
+		}
+		if r.IsOptioned {
+			set(&cm.Optioned, r.OptionPunter, i)
+		}
+	}
+
+	return cm, true
+}
+
+// expand returns p with the ownership recorded in cm. The rivers are in the
+// order in which the map was packed.
+func (p *packedMap) expand(cm *compactMap) *Map {
+	m := &Map{
+		Sites:  p.m.Sites,
+		Rivers: append([]River(nil), p.m.Rivers...),
+		Mines:  p.m.Mines,
+	}
+	has := func(sets [][]byte, punter, i int) bool {
+		return i/8 < len(sets[punter]) && sets[punter][i/8]&(1<<uint(i%8)) != 0
+	}
+	for i := range m.Rivers {
+		r := &m.Rivers[i]
+		for punter := range cm.Owned {
+			if has(cm.Owned, punter, i) {
+				r.IsOwned, r.OwnerPunter = true, uint64(punter)
+			}
+		}
+		for punter := range cm.Optioned {
+			if has(cm.Optioned, punter, i) {
+				r.IsOptioned, r.OptionPunter = true, uint64(punter)
+			}
+		}
+	}
+	return m
+}
+
+// compactGame passes the state of Game compactly.
+type compactGame struct {
+	Game
+
+	// encode is false if states are only decoded.
+	encode bool
+
+	// p is the map of the game, once known, and field the top-level field
+	// of the state that last held it.
+	p     *packedMap
+	field string
+}
+
+// CompactGame returns a Game that plays as g, but passes g's state between
+// stages compactly. This is just gzip plus bitsets: whichever top-level field
+// of g's state holds the map given at setup is taken out, and the map carried
+// instead as a gzipped gob without ownership, which doesn't change during the
+// game, and bitsets of who owns and holds options on each river. The rest of
+// the state is gzipped. The map is restored before g sees the state again,
+// with the rivers in the order the server gave them.
+//
+// With encode false, g's states are passed on as they are, but compact states
+// are still decoded, so a Game may always be wrapped, whether or not its
+// states should be compact.
+//
+// This makes the state smaller, not quicker to handle: the map is still
+// marshalled to and from JSON for g.
+func CompactGame(g Game, encode bool) Game {
+	return &compactGame{Game: g, encode: encode}
+}
+
+func (c *compactGame) compress(state interface{}) (interface{}, error) {
+	if !c.encode {
+		return state, nil
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &compactState{}
+
+	// Look for the map where it was last time first.
+	var fields map[string]json.RawMessage
+	if c.p != nil && json.Unmarshal(b, &fields) == nil {
+		keys := []string{c.field}
+		for k := range fields {
+			if k != c.field {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			v := bytes.TrimSpace(fields[k])
+			if len(v) == 0 || v[0] != '{' {
+				continue
+			}
+			var m struct {
+				Rivers []River `json:"rivers"`
+			}
+			if json.Unmarshal(v, &m) != nil {
+				continue
+			}
+			if cm, ok := c.p.compact(m.Rivers); ok {
+				cs.Field, cs.Map = k, cm
+				c.field = k
+				break
+			}
+		}
+	}
+	if cs.Map != nil {
+		delete(fields, cs.Field)
+		if b, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	cs.Data = buf.Bytes()
+
+	return cs, nil
+}
+
+// expand returns state as g encoded it. States that weren't encoded by
+// CompactGame are returned unchanged.
+func (c *compactGame) expand(state json.RawMessage) (json.RawMessage, error) {
+	var cs compactState
+	if err := json.Unmarshal(state, &cs); err != nil || cs.Data == nil {
+		return state, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(cs.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress state: %v", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress state: %v", err)
+	}
+	if cs.Map == nil {
+		return b, nil
+	}
+
+	// The map is only decoded again if it isn't the one last seen.
+	if c.p == nil || !bytes.Equal(c.p.data, cs.Map.Data) {
+		if c.p, err = unpackMap(cs.Map); err != nil {
+			return nil, err
+		}
+	}
+	c.field = cs.Field
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %v", err)
+	}
+	if fields[cs.Field], err = json.Marshal(c.p.expand(cs.Map)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func (c *compactGame) Setup(s *Setup) (*Ready, error) {
+	if c.encode {
+		var err error
+		if c.p, err = packMap(&s.Map); err != nil {
+			return nil, fmt.Errorf("failed to pack map: %v", err)
+		}
+	}
+
+	r, err := c.Game.Setup(s)
+	if err != nil {
+		return nil, err
+	}
+	if r.State, err = c.compress(r.State); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %v", err)
+	}
+	return r, nil
+}
+
+func (c *compactGame) Play(m []Move, state json.RawMessage) (*GameplayOutput, error) {
+	state, err := c.expand(state)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := c.Game.Play(m, state)
+	if err != nil {
+		return nil, err
+	}
+	if out.State, err = c.compress(out.State); err != nil {
+		return nil, fmt.Errorf("failed to compress state: %v", err)
+	}
+	return out, nil
+}
+
+func (c *compactGame) Stop(s *Stop, state json.RawMessage) error {
+	state, err := c.expand(state)
+	if err != nil {
+		return err
+	}
+	return c.Game.Stop(s, state)
+}
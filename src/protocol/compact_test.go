@@ -0,0 +1,159 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/bot"
+	"github.com/jemoster/icfp2017/src/moves"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// recorder is a Game that hands back the given state at every stage, and
+// remembers the state it was last given.
+type recorder struct {
+	state interface{}
+	got   json.RawMessage
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Setup(s *protocol.Setup) (*protocol.Ready, error) {
+	return &protocol.Ready{Ready: s.Punter, State: r.state}, nil
+}
+
+func (r *recorder) Play(m []protocol.Move, state json.RawMessage) (*protocol.GameplayOutput, error) {
+	r.got = state
+	return &protocol.GameplayOutput{State: r.state}, nil
+}
+
+func (r *recorder) Stop(s *protocol.Stop, state json.RawMessage) error {
+	r.got = state
+	return nil
+}
+
+func square() protocol.Map {
+	m := protocol.Map{Mines: []protocol.SiteID{0, 2}}
+	for i := 0; i < 4; i++ {
+		m.Sites = append(m.Sites, protocol.Site{ID: protocol.SiteID(i)})
+	}
+	for i := 0; i < 4; i++ {
+		m.Rivers = append(m.Rivers, protocol.River{
+			Source: protocol.SiteID(i), Target: protocol.SiteID((i + 1) % 4),
+		})
+	}
+	return m
+}
+
+// sameJSON reports whether a and b are the same JSON, but for the order of
+// object keys and whitespace.
+func sameJSON(t *testing.T, a, b []byte) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("Bad JSON %s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("Bad JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	setup := &protocol.Setup{Punter: 1, Punters: 3, Map: square(), Settings: protocol.Settings{Options: true}}
+
+	// The state at setup, before anything is claimed, and later, with
+	// rivers owned and optioned by several punters, given by the server in
+	// a different order and direction.
+	initial := &bot.State{Punter: 1, Punters: 3, Map: square(), Settings: setup.Settings, Ledger: moves.NewLedger(setup)}
+	later := &bot.State{Punter: 1, Punters: 3, Map: square(), Settings: setup.Settings, Turn: 2, Ledger: moves.NewLedger(setup)}
+	later.Ledger.Options[2]--
+	later.Ledger.Credit[1] = 1
+	r := later.Map.Rivers
+	r[0], r[3] = r[3], r[0]
+	r[1].Source, r[1].Target = r[1].Target, r[1].Source
+	r[0].IsOwned, r[0].OwnerPunter = true, 2
+	r[1].IsOwned, r[1].OwnerPunter = true, 0
+	r[1].IsOptioned, r[1].OptionPunter = true, 2
+	r[2].IsOwned, r[2].OwnerPunter = true, 1
+
+	// Each stage is a new process, so a new Game.
+	ready, err := protocol.CompactGame(&recorder{state: initial}, true).Setup(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := json.Marshal(ready.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{state: later}
+	out, err := protocol.CompactGame(rec, true).Play(nil, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := json.Marshal(initial); !sameJSON(t, rec.got, want) {
+		t.Errorf("Setup state decoded to %s, want %s", rec.got, want)
+	}
+	if state, err = json.Marshal(out.State); err != nil {
+		t.Fatal(err)
+	}
+
+	// The map must have been taken out of the state.
+	var cs struct {
+		Field string
+		Map   json.RawMessage
+	}
+	if err := json.Unmarshal(state, &cs); err != nil {
+		t.Fatal(err)
+	}
+	if cs.Field != "Map" || cs.Map == nil {
+		t.Errorf("The map was left in the state: %s", state)
+	}
+
+	rec = &recorder{}
+	if err := protocol.CompactGame(rec, false).Stop(&protocol.Stop{}, state); err != nil {
+		t.Fatal(err)
+	}
+	var got bot.State
+	if err := json.Unmarshal(rec.got, &got); err != nil {
+		t.Fatal(err)
+	}
+	// The rivers come back in the order the server gave at setup.
+	r[0], r[3] = r[3], r[0]
+	r[1].Source, r[1].Target = r[1].Target, r[1].Source
+	if !reflect.DeepEqual(got, *later) {
+		t.Errorf("Play state decoded to %+v, want %+v", got, *later)
+	}
+}
+
+func TestCompactPassThrough(t *testing.T) {
+	setup := &protocol.Setup{Punter: 0, Punters: 2, Map: square()}
+	state := &bot.State{Punter: 0, Punters: 2, Map: square(), Ledger: moves.NewLedger(setup)}
+
+	rec := &recorder{state: state}
+	g := protocol.CompactGame(rec, false)
+	ready, err := g.Setup(setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.State != state {
+		t.Errorf("Setup state changed to %v", ready.State)
+	}
+
+	plain, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := g.Play(nil, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.got, plain) {
+		t.Errorf("Play was given %s, want %s", rec.got, plain)
+	}
+	if out.State != state {
+		t.Errorf("Play state changed to %v", out.State)
+	}
+}
@@ -40,13 +40,13 @@ type HandshakeClientServer struct {
 }
 
 type HandshakeServerClient struct {
-	You string `json:"me"`
+	You string `json:"you"`
 }
 
 type Settings struct {
-	Futures  bool `json:futures"`
-	Splurges bool `json:splurges"`
-	Options  bool `json:options"`
+	Futures  bool `json:"futures"`
+	Splurges bool `json:"splurges"`
+	Options  bool `json:"options"`
 }
 
 type Setup struct {
@@ -56,9 +56,19 @@ type Setup struct {
 	Settings Settings `json:"settings"`
 }
 
+// Future is a bet that Source, a mine, will be connected to Target by the end
+// of the game.
+type Future struct {
+	Source SiteID `json:"source"`
+	Target SiteID `json:"target"`
+}
+
 type Ready struct {
 	Ready uint64 `json:"ready"`
 
+	// Futures may only be bid if enabled in Settings.
+	Futures []Future `json:"futures,omitempty"`
+
 	// State is the Game's internal state, which will be marshalled to
 	// JSON.
 	State interface{} `json:"state"`
@@ -89,6 +99,22 @@ type Move struct {
 	Option  *Option  `json:"option,omitempty"`
 }
 
+// Punter returns the punter that made the move, or false if the move is
+// empty.
+func (m Move) Punter() (uint64, bool) {
+	switch {
+	case m.Claim != nil:
+		return m.Claim.Punter, true
+	case m.Pass != nil:
+		return m.Pass.Punter, true
+	case m.Splurge != nil:
+		return m.Splurge.Punter, true
+	case m.Option != nil:
+		return m.Option.Punter, true
+	}
+	return 0, false
+}
+
 func (m Move) String() string {
 	if m.Claim != nil {
 		return fmt.Sprintf("{Claim: %+v}", m.Claim)
@@ -96,6 +122,12 @@ func (m Move) String() string {
 	if m.Pass != nil {
 		return fmt.Sprintf("{Pass: %+v}", m.Pass)
 	}
+	if m.Splurge != nil {
+		return fmt.Sprintf("{Splurge: %+v}", m.Splurge)
+	}
+	if m.Option != nil {
+		return fmt.Sprintf("{Option: %+v}", m.Option)
+	}
 	return "{<nil>}"
 }
 
@@ -126,11 +158,30 @@ type Score struct {
 	Score  int64  `json:"score"`
 }
 
+// PunterFutures are the futures accepted for one punter.
+type PunterFutures struct {
+	Punter  uint64   `json:"punter"`
+	Futures []Future `json:"futures"`
+}
+
 type Stop struct {
 	Moves  []Move  `json:"moves"`
 	Scores []Score `json:"scores"`
+
+	// State additions not covered in the official protocol.
+	Futures []PunterFutures `json:"futures,omitempty"`
 }
 
 type Timeout struct {
 	Timeout float64 `json:"timeout"`
 }
+
+// ServerMessage is any message the server may send a punter after setup, in
+// online mode.
+type ServerMessage struct {
+	Move *struct {
+		Moves []Move `json:"moves"`
+	} `json:"move"`
+	Stop    *Stop    `json:"stop"`
+	Timeout *float64 `json:"timeout"`
+}
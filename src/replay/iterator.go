@@ -0,0 +1,95 @@
+package replay
+
+import (
+	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Iterator steps through the states of the board, one move at a time.
+//
+//	it := t.Iterate()
+//	for it.Next() {
+//		scores := it.Scores()
+//		...
+//	}
+type Iterator struct {
+	t     *Transcript
+	moves []protocol.Move
+	turn  int
+
+	graph *graph.Graph
+	dist  graph.Distances
+}
+
+// Iterate returns an Iterator positioned before the first move.
+func (t *Transcript) Iterate() *Iterator {
+	m := t.Setup.Map
+	m.Rivers = append([]protocol.River(nil), m.Rivers...)
+
+	g := graph.New(&m, func(*graph.MetadataEdge) float64 { return 1.0 })
+	dist := g.ShortestDistances(m.Mines)
+	g.TrackScore(m.Mines, func(p uint64, mine, site protocol.SiteID) int64 {
+		d := int64(dist[mine][site])
+		return d * d
+	})
+
+	return &Iterator{
+		t:     t,
+		moves: t.Moves(),
+		turn:  -1,
+		graph: g,
+		dist:  dist,
+	}
+}
+
+// Next applies the next move, returning false once all moves are applied.
+func (it *Iterator) Next() bool {
+	if it.turn+1 >= len(it.moves) {
+		return false
+	}
+
+	it.turn++
+	it.graph.Update(it.moves[it.turn : it.turn+1])
+	return true
+}
+
+// Turn returns the index of the last move applied.
+func (it *Iterator) Turn() int {
+	return it.turn
+}
+
+// Move returns the last move applied.
+func (it *Iterator) Move() protocol.Move {
+	return it.moves[it.turn]
+}
+
+// Graph returns the board after the last move applied. It is updated in
+// place by Next, so callers that need to keep a state must copy it.
+func (it *Iterator) Graph() *graph.Graph {
+	return it.graph
+}
+
+// Scores returns each punter's score after the last move applied.
+//
+// Only our own futures are known, so other punters are scored without theirs.
+func (it *Iterator) Scores() []protocol.Score {
+	t := it.t
+
+	scores := make([]protocol.Score, t.Setup.Punters)
+	for i := range scores {
+		scores[i].Punter = uint64(i)
+		scores[i].Score = it.graph.RunningScore(uint64(i))
+	}
+
+	if t.Ready == nil || !t.Setup.Settings.Futures {
+		return scores
+	}
+
+	us := &scores[t.Setup.Punter]
+	for _, f := range t.Ready.Futures {
+		d := int64(it.dist[f.Source][f.Target])
+		us.Score += graph.ScoreFuture(d, it.graph.Connected(t.Setup.Punter, f.Source, f.Target))
+	}
+
+	return scores
+}
@@ -0,0 +1,214 @@
+// Package replay parses the transcripts recorded by the online adapters
+// (tools/bot_runner/online_adapter.py --record and cmd/online) and
+// reconstructs the state of the board after every move.
+//
+// A transcript is an optional JSON metadata line followed by one line per
+// message: ">> " prefixes messages sent to the server and "<< " messages
+// received from it.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Message is a single message in a transcript.
+type Message struct {
+	// Sent is true for messages sent to the server.
+	Sent bool
+
+	// Raw is the message as recorded.
+	Raw json.RawMessage
+}
+
+// Transcript is a parsed transcript of one punter's game.
+type Transcript struct {
+	// Metadata is the first line of the transcript, if present. Its
+	// contents vary between recorders.
+	Metadata json.RawMessage
+
+	// Messages are all messages in the order they were recorded.
+	Messages []Message
+
+	// Name is the name we gave in the handshake.
+	Name string
+
+	Setup *protocol.Setup
+
+	// Ready is our response to Setup, including any futures bid.
+	Ready *protocol.Ready
+
+	// Requests are the moves reported in each move request, in order.
+	Requests [][]protocol.Move
+
+	// Sent are the moves we sent, in order.
+	Sent []protocol.Move
+
+	// Timeouts is the number of timeout messages received.
+	Timeouts int
+
+	// Stop is the final message, or nil if the game did not finish.
+	Stop *protocol.Stop
+}
+
+var (
+	sentPrefix     = []byte(">> ")
+	receivedPrefix = []byte("<< ")
+)
+
+// Parse parses a transcript from r.
+func Parse(r io.Reader) (*Transcript, error) {
+	t := new(Transcript)
+
+	s := bufio.NewScanner(r)
+	// Setup messages contain the whole map, so lines can be very long.
+	s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for line := 1; s.Scan(); line++ {
+		b := bytes.TrimSpace(s.Bytes())
+
+		var m Message
+		switch {
+		case len(b) == 0:
+			continue
+		case bytes.HasPrefix(b, sentPrefix):
+			m.Sent = true
+			m.Raw = append(json.RawMessage(nil), b[len(sentPrefix):]...)
+		case bytes.HasPrefix(b, receivedPrefix):
+			m.Raw = append(json.RawMessage(nil), b[len(receivedPrefix):]...)
+		case line == 1:
+			t.Metadata = append(json.RawMessage(nil), b...)
+			continue
+		default:
+			return nil, fmt.Errorf("line %d: unknown message direction: %.20q", line, b)
+		}
+
+		t.Messages = append(t.Messages, m)
+		if err := t.decode(m); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if t.Setup == nil {
+		return nil, fmt.Errorf("transcript has no setup")
+	}
+
+	return t, nil
+}
+
+// ParseFile parses the transcript in the named file.
+func ParseFile(name string) (*Transcript, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// decode interprets m in the context of the messages before it.
+func (t *Transcript) decode(m Message) error {
+	switch {
+	case m.Sent && t.Name == "":
+		var h protocol.HandshakeClientServer
+		if err := json.Unmarshal(m.Raw, &h); err != nil {
+			return fmt.Errorf("bad handshake: %v", err)
+		}
+		t.Name = h.Me
+	case m.Sent && t.Ready == nil:
+		t.Ready = new(protocol.Ready)
+		if err := json.Unmarshal(m.Raw, t.Ready); err != nil {
+			return fmt.Errorf("bad ready: %v", err)
+		}
+	case m.Sent:
+		var move protocol.Move
+		if err := json.Unmarshal(m.Raw, &move); err != nil {
+			return fmt.Errorf("bad move: %v", err)
+		}
+		t.Sent = append(t.Sent, move)
+	case t.Setup == nil:
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(m.Raw, &probe); err != nil {
+			return err
+		}
+		if _, ok := probe["map"]; !ok {
+			// The handshake.
+			return nil
+		}
+
+		t.Setup = new(protocol.Setup)
+		if err := json.Unmarshal(m.Raw, t.Setup); err != nil {
+			return fmt.Errorf("bad setup: %v", err)
+		}
+	default:
+		var sm protocol.ServerMessage
+		if err := json.Unmarshal(m.Raw, &sm); err != nil {
+			return fmt.Errorf("bad server message: %v", err)
+		}
+
+		switch {
+		case sm.Stop != nil:
+			t.Stop = sm.Stop
+		case sm.Timeout != nil:
+			t.Timeouts++
+		case sm.Move != nil:
+			t.Requests = append(t.Requests, sm.Move.Moves)
+		}
+	}
+
+	return nil
+}
+
+// Moves returns every move of the game, by any punter, in the order they
+// were made.
+//
+// Each move request reports the last move of every punter, so some moves are
+// reported more than once and, in the first request, punters that haven't
+// moved yet are reported as passing. Moves are therefore matched to turns:
+// punters move in order, so the punter of turn t is t mod the number of
+// punters.
+func (t *Transcript) Moves() []protocol.Move {
+	punters := t.Setup.Punters
+	if punters == 0 {
+		return nil
+	}
+
+	var moves []protocol.Move
+	take := func(reported []protocol.Move, until uint64) {
+		for turn := uint64(len(moves)); turn < until; turn++ {
+			punter := turn % punters
+
+			move := protocol.Move{Pass: &protocol.Pass{Punter: punter}}
+			for _, m := range reported {
+				if p, ok := m.Punter(); ok && p == punter {
+					move = m
+					break
+				}
+			}
+			moves = append(moves, move)
+		}
+	}
+
+	// Our n-th move request reports every move before our n-th turn.
+	next := t.Setup.Punter
+	for _, reported := range t.Requests {
+		take(reported, next)
+		next += punters
+	}
+
+	if t.Stop != nil {
+		take(t.Stop.Moves, uint64(len(t.Setup.Map.Rivers)))
+	}
+
+	return moves
+}
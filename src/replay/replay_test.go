@@ -0,0 +1,95 @@
+package replay_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jemoster/icfp2017/src/replay"
+)
+
+var fixtures = []struct {
+	path     string
+	name     string
+	punter   uint64
+	punters  uint64
+	requests int
+}{
+	{"../../tools/replay/server/sample.txt", "prattmic-longwalk", 2, 3, 27},
+	{"../../tools/replay/server/brownian-test.2.txt", "Brownian", 3, 4, 15},
+}
+
+func TestParseFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		tr, err := replay.ParseFile(f.path)
+		if err != nil {
+			t.Errorf("%s: %v", f.path, err)
+			continue
+		}
+
+		var meta map[string]interface{}
+		if err := json.Unmarshal(tr.Metadata, &meta); err != nil || meta["server"] == nil {
+			t.Errorf("%s: metadata %s not parsed: %v", f.path, tr.Metadata, err)
+		}
+		if tr.Name != f.name {
+			t.Errorf("%s: name %q, want %q", f.path, tr.Name, f.name)
+		}
+		if tr.Setup.Punter != f.punter || tr.Setup.Punters != f.punters {
+			t.Errorf("%s: punter %d of %d, want %d of %d", f.path, tr.Setup.Punter, tr.Setup.Punters, f.punter, f.punters)
+		}
+		if tr.Ready == nil || tr.Ready.Ready != f.punter {
+			t.Errorf("%s: ready %+v, want ready %d", f.path, tr.Ready, f.punter)
+		}
+		if len(tr.Requests) != f.requests || len(tr.Sent) != f.requests {
+			t.Errorf("%s: %d requests and %d moves sent, want %d of each", f.path, len(tr.Requests), len(tr.Sent), f.requests)
+		}
+		if tr.Stop == nil {
+			t.Errorf("%s: no stop", f.path)
+			continue
+		}
+
+		// Every river is a turn, each punter's in order, and ours are
+		// the moves we sent.
+		moves := tr.Moves()
+		if len(moves) != len(tr.Setup.Map.Rivers) {
+			t.Errorf("%s: %d moves, want one for each of %d rivers", f.path, len(moves), len(tr.Setup.Map.Rivers))
+		}
+		for turn, m := range moves {
+			if p, ok := m.Punter(); !ok || p != uint64(turn)%f.punters {
+				t.Errorf("%s: turn %d made by punter %d, want %d", f.path, turn, p, uint64(turn)%f.punters)
+			}
+		}
+		for i, m := range tr.Sent {
+			turn := int(f.punter + uint64(i)*f.punters)
+			if turn < len(moves) && !reflect.DeepEqual(moves[turn], m) {
+				t.Errorf("%s: turn %d is %v, but we sent %v", f.path, turn, moves[turn], m)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		transcript string
+	}{
+		{"no setup", `>> {"me": "x"}
+<< {"you": "x"}
+`},
+		{"unknown direction", `{"metadata": 0}
+>> {"me": "x"}
+?? {"you": "x"}
+`},
+		{"bad move", `>> {"me": "x"}
+<< {"you": "x"}
+<< {"punter": 0, "punters": 2, "map": {"sites": [], "rivers": [], "mines": []}}
+>> {"ready": 0}
+>> {"claim": 3}
+`},
+	} {
+		if _, err := replay.Parse(strings.NewReader(tt.transcript)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Config describes a set of independent sessions, each hosted on its own
+// port, like the official lobby.
+//
+// Fields left out of a SessionConfig take their value from the command line
+// flags.
+type Config struct {
+	Sessions []SessionConfig `json:"sessions"`
+}
+
+// SessionConfig describes the games hosted on one port.
+type SessionConfig struct {
+	Port    int    `json:"port"`
+	Map     string `json:"map"`
+	Punters int    `json:"punters"`
+
+	Settings *protocol.Settings `json:"settings,omitempty"`
+
+	RunOnce *bool `json:"runonce,omitempty"`
+
+	// Timeouts are in time.ParseDuration format, e.g. "1s".
+	SetupTimeout string `json:"setuptimeout,omitempty"`
+	MoveTimeout  string `json:"movetimeout,omitempty"`
+	MaxTimeouts  *int   `json:"maxtimeouts,omitempty"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	if len(c.Sessions) == 0 {
+		return nil, fmt.Errorf("config %q has no sessions", path)
+	}
+
+	return c, nil
+}
+
+// server returns the Server described by sc. Unset fields are taken from
+// defaults, except for Map, which must already be loaded by the caller.
+func (sc *SessionConfig) server(m *protocol.Map, defaults *Server) (*Server, error) {
+	srv := *defaults
+	srv.Map = *m
+	srv.MapPath = sc.Map
+
+	if sc.Port == 0 {
+		return nil, fmt.Errorf("session for map %q has no port", sc.Map)
+	}
+	srv.Port = sc.Port
+
+	if sc.Punters > 0 {
+		srv.NumPunters = sc.Punters
+	}
+	if sc.Settings != nil {
+		srv.Settings = *sc.Settings
+	}
+	if sc.RunOnce != nil {
+		srv.RunOnce = *sc.RunOnce
+	}
+	if sc.MaxTimeouts != nil {
+		srv.MaxTimeouts = *sc.MaxTimeouts
+	}
+
+	var err error
+	if sc.SetupTimeout != "" {
+		if srv.SetupTimeout, err = time.ParseDuration(sc.SetupTimeout); err != nil {
+			return nil, fmt.Errorf("port %d: bad setup timeout: %v", sc.Port, err)
+		}
+	}
+	if sc.MoveTimeout != "" {
+		if srv.MoveTimeout, err = time.ParseDuration(sc.MoveTimeout); err != nil {
+			return nil, fmt.Errorf("port %d: bad move timeout: %v", sc.Port, err)
+		}
+	}
+
+	return &srv, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Session states, as shown in the lobby.
+const (
+	stateWaiting    = "waiting"
+	stateInProgress = "in progress"
+	stateFinished   = "finished"
+)
+
+// SessionStatus describes a session in the lobby. The field names follow the
+// official server's status.json, so tools/bot_runner/server_status.py can
+// read either.
+type SessionStatus struct {
+	Port         int      `json:"port"`
+	MapName      string   `json:"map_name"`
+	Settings     Settings `json:"settings"`
+	Extensions   []string `json:"extensions"`
+	TotalPunters int      `json:"total_punters"`
+
+	// Punters are the names of the punters seated so far.
+	Punters   []string `json:"punters"`
+	FreeSeats int      `json:"free_seats"`
+
+	State string `json:"state"`
+
+	// Status is a human readable description of State.
+	Status string `json:"status"`
+
+	Turn  int `json:"turn"`
+	Turns int `json:"turns"`
+
+	// Scores are set once the game is finished.
+	Scores []Score `json:"scores,omitempty"`
+}
+
+// Lobby tracks the status of every session, for display over HTTP. A nil
+// Lobby ignores updates.
+type Lobby struct {
+	mu       sync.Mutex
+	sessions map[int]*SessionStatus
+	streams  map[int]*stream
+}
+
+// NewLobby returns an empty Lobby.
+func NewLobby() *Lobby {
+	return &Lobby{
+		sessions: make(map[int]*SessionStatus),
+		streams:  make(map[int]*stream),
+	}
+}
+
+// open lists a new session waiting for punters, replacing any previous
+// session on the same port.
+func (l *Lobby) open(s *Session) {
+	if l == nil {
+		return
+	}
+
+	status := &SessionStatus{
+		Port:         s.Port,
+		MapName:      filepath.Base(s.MapPath),
+		Settings:     s.Settings,
+		Extensions:   []string{},
+		TotalPunters: s.NumPunters,
+		Punters:      []string{},
+		Turns:        len(s.Map.Rivers),
+	}
+	if s.Settings.Futures {
+		status.Extensions = append(status.Extensions, "futures")
+	}
+	if s.Settings.Splurges {
+		status.Extensions = append(status.Extensions, "splurges")
+	}
+	if s.Settings.Options {
+		status.Extensions = append(status.Extensions, "options")
+	}
+	status.setState(stateWaiting)
+
+	l.mu.Lock()
+	l.sessions[s.Port] = status
+	l.mu.Unlock()
+
+	l.publish(s.Port, "setup", setupEvent{
+		Punters:  s.NumPunters,
+		Map:      &s.Map,
+		Settings: s.Settings,
+	})
+}
+
+// update applies f to the status of the session on port.
+func (l *Lobby) update(port int, f func(*SessionStatus)) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if status, ok := l.sessions[port]; ok {
+		f(status)
+	}
+}
+
+// seat records that a punter has taken a seat.
+func (l *Lobby) seat(port int, name string) {
+	l.update(port, func(s *SessionStatus) {
+		s.Punters = append(s.Punters, name)
+		s.setState(s.State)
+	})
+}
+
+func (l *Lobby) setState(port int, state string) {
+	l.update(port, func(s *SessionStatus) {
+		s.setState(state)
+	})
+}
+
+func (l *Lobby) setTurn(port, turn int) {
+	l.update(port, func(s *SessionStatus) {
+		s.Turn = turn
+	})
+}
+
+func (l *Lobby) finish(port int, scores []Score) {
+	l.update(port, func(s *SessionStatus) {
+		s.Scores = scores
+		s.Turn = s.Turns
+		s.setState(stateFinished)
+	})
+}
+
+func (s *SessionStatus) setState(state string) {
+	s.State = state
+	s.FreeSeats = s.TotalPunters - len(s.Punters)
+
+	switch state {
+	case stateWaiting:
+		s.Status = fmt.Sprintf("Waiting for punters. (%d/%d)", len(s.Punters), s.TotalPunters)
+	case stateInProgress:
+		s.Status = "Game in progress."
+	case stateFinished:
+		s.Status = "Game finished."
+	}
+}
+
+// Status returns a copy of the status of every session, ordered by port.
+func (l *Lobby) Status() []SessionStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var ports []int
+	for port := range l.sessions {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	status := make([]SessionStatus, 0, len(ports))
+	for _, port := range ports {
+		s := *l.sessions[port]
+		s.Punters = append([]string{}, s.Punters...)
+		status = append(status, s)
+	}
+	return status
+}
+
+var lobbyTemplate = template.Must(template.New("lobby").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Lobby</title>
+<meta http-equiv="refresh" content="5">
+</head>
+<body>
+<table border="1">
+<tr><th>Status</th><th>Punters</th><th>Extensions</th><th>Port</th><th>Map Name</th></tr>
+{{range .}}<tr><td>{{.Status}}</td><td>{{range $i, $p := .Punters}}{{if $i}}, {{end}}{{$p}}{{end}}</td><td>{{range $i, $e := .Extensions}}{{if $i}}, {{end}}{{$e}}{{end}}</td><td>{{.Port}}</td><td>{{.MapName}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// ServeHTTP serves the lobby as JSON at /status.json and as HTML at
+// /status.html and /, and live games at /events/<port>.
+func (l *Lobby) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/events/") {
+		l.serveEvents(w, r)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/status.json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Games []SessionStatus `json:"games"`
+		}{l.Status()})
+	case "/", "/status.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := lobbyTemplate.Execute(w, l.Status()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	. "github.com/jemoster/icfp2017/src/protocol"
+)
+
+func TestStatusJSON(t *testing.T) {
+	l := NewLobby()
+
+	m := Map{
+		Sites:  []Site{{ID: 0}, {ID: 1}, {ID: 2}},
+		Rivers: []River{{Source: 0, Target: 1}, {Source: 1, Target: 2}},
+		Mines:  []SiteID{0},
+	}
+	l.open(&Session{Map: m, MapPath: "maps/tiny.json", Port: 9002, NumPunters: 2, Settings: Settings{Futures: true, Options: true}})
+	l.seat(9002, "alice")
+	l.open(&Session{Map: m, MapPath: "maps/tiny.json", Port: 9001, NumPunters: 2})
+	l.seat(9001, "bob")
+	l.seat(9001, "carol")
+	l.setState(9001, stateInProgress)
+	l.finish(9001, []Score{{Punter: 0, Score: 1}, {Punter: 1, Score: 4}})
+
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	var status struct {
+		Games []map[string]interface{} `json:"games"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fields the official server's status.json has, and which
+	// tools/bot_runner/server_status.py reads.
+	want := []map[string]interface{}{
+		{
+			"port":          9001.0,
+			"map_name":      "tiny.json",
+			"settings":      map[string]interface{}{"futures": false, "splurges": false, "options": false},
+			"extensions":    []interface{}{},
+			"total_punters": 2.0,
+			"punters":       []interface{}{"bob", "carol"},
+			"free_seats":    0.0,
+			"state":         stateFinished,
+			"status":        "Game finished.",
+			"turn":          2.0,
+			"turns":         2.0,
+			"scores": []interface{}{
+				map[string]interface{}{"punter": 0.0, "score": 1.0},
+				map[string]interface{}{"punter": 1.0, "score": 4.0},
+			},
+		},
+		{
+			"port":          9002.0,
+			"map_name":      "tiny.json",
+			"settings":      map[string]interface{}{"futures": true, "splurges": false, "options": true},
+			"extensions":    []interface{}{"futures", "options"},
+			"total_punters": 2.0,
+			"punters":       []interface{}{"alice"},
+			"free_seats":    1.0,
+			"state":         stateWaiting,
+			"status":        "Waiting for punters. (1/2)",
+			"turn":          0.0,
+			"turns":         2.0,
+		},
+	}
+	if !reflect.DeepEqual(status.Games, want) {
+		t.Errorf("status.json games:\n%v\nwant:\n%v", status.Games, want)
+	}
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/jemoster/icfp2017/src/protocol"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path"
+	"sync"
+	"time"
 )
 
 func loadMap(path string) (*protocol.Map, error) {
@@ -42,51 +44,108 @@ func main() {
 	splurges := flag.Bool("splurges", true, "to disable splurges use --splurges=false")
 	options := flag.Bool("options", true, "to disable options use --options=false")
 
+	setupTimeout := flag.Duration("setuptimeout", 10*time.Second, "time allowed for the handshake and setup")
+	moveTimeout := flag.Duration("movetimeout", time.Second, "time allowed for each move")
+	maxTimeouts := flag.Int("maxtimeouts", 10, "number of timeouts after which a punter becomes a zombie (0 to disable)")
+
 	runOnce := flag.Bool("runonce", false, "to run only one session use --runonce=true")
-	resultsDir := flag.String("results", "results", "directory in which to place log files.")
+	resultsDir := flag.String("results", "results", "directory in which to place a JSON record of each game.")
+
+	httpAddr := flag.String("http", ":8000", "address on which to serve the lobby's status page (empty to disable)")
+
+	configPath := flag.String("config", "", "A JSON file describing sessions to host concurrently. Overrides --map and --port; other flags provide defaults.")
 
 	flag.Parse()
 
-	if len(*mapPath) < 1 {
-		log.Fatal("map can not be undefined")
+	defaults := Server{
+		NumPunters: *numPunters,
+		Settings: protocol.Settings{
+			Futures:  *futures,
+			Splurges: *splurges,
+			Options:  *options,
+		},
+		RunOnce: *runOnce,
+
+		SetupTimeout: *setupTimeout,
+		MoveTimeout:  *moveTimeout,
+		MaxTimeouts:  *maxTimeouts,
 	}
 
-	mapData, err := loadMap(*mapPath)
-	if err != nil {
-		log.Fatal(err)
+	if *httpAddr != "" {
+		defaults.Lobby = NewLobby()
 	}
 
-	fmt.Printf("Map Loaded (from %q)\n", *mapPath)
-	fmt.Printf("  Sites:  %d\n", len(mapData.Sites))
-	fmt.Printf("  Rivers: %d\n", len(mapData.Rivers))
-	fmt.Printf("  Mines:  %d\n", len(mapData.Mines))
+	config := &Config{
+		Sessions: []SessionConfig{{Port: *srvPort, Map: *mapPath}},
+	}
+	if *configPath != "" {
+		var err error
+		config, err = loadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	if _, err := os.Stat(*resultsDir); os.IsNotExist(err) {
 		os.Mkdir(*resultsDir, os.ModePerm)
 	}
 
-	resultsFileName := path.Join(*resultsDir, fmt.Sprintf("%s.log", path.Base(*mapPath)))
+	results := &Results{Dir: *resultsDir}
 
-	resultsFile, err := os.OpenFile(resultsFileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
-	}
-	defer resultsFile.Close()
+	maps := make(map[string]*protocol.Map)
 
-	resultsWriter := bufio.NewWriter(resultsFile)
-	defer resultsWriter.Flush()
+	var servers []*Server
+	for i := range config.Sessions {
+		sc := &config.Sessions[i]
 
-	serv := Server{
-		Map:        *mapData,
-		Port:       *srvPort,
-		NumPunters: *numPunters,
-		Settings: protocol.Settings{
-			Futures:  *futures,
-			Splurges: *splurges,
-			Options:  *options,
-		},
-		RunOnce: *runOnce,
+		if len(sc.Map) < 1 {
+			log.Fatal("map can not be undefined")
+		}
+
+		mapData, ok := maps[sc.Map]
+		if !ok {
+			var err error
+			mapData, err = loadMap(sc.Map)
+			if err != nil {
+				log.Fatal(err)
+			}
+			maps[sc.Map] = mapData
+
+			fmt.Printf("Map Loaded (from %q)\n", sc.Map)
+			fmt.Printf("  Sites:  %d\n", len(mapData.Sites))
+			fmt.Printf("  Rivers: %d\n", len(mapData.Rivers))
+			fmt.Printf("  Mines:  %d\n", len(mapData.Mines))
+		}
+
+		srv, err := sc.server(mapData, &defaults)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		servers = append(servers, srv)
 	}
 
-	serv.run(resultsWriter)
+	if defaults.Lobby != nil {
+		go func() {
+			fmt.Printf("Serving lobby at http://%s/status.html\n", *httpAddr)
+			if err := http.ListenAndServe(*httpAddr, defaults.Lobby); err != nil {
+				log.Printf("Lobby stopped: %v", err)
+			}
+		}()
+	}
+
+	// Each server runs independently; one failing to listen does not stop
+	// the others.
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(srv *Server) {
+			defer wg.Done()
+
+			if err := srv.run(results); err != nil {
+				srv.logf("[ERROR] server stopped: %v\n", err)
+			}
+		}(servers[i])
+	}
+	wg.Wait()
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/jemoster/icfp2017/src/engine"
+	. "github.com/jemoster/icfp2017/src/protocol"
+)
+
+// MapRecord identifies the map a game was played on.
+type MapRecord struct {
+	Path string `json:"path"`
+
+	// SHA256 is the hash of the map's JSON encoding, to tell apart maps
+	// that have changed on disk.
+	SHA256 string `json:"sha256"`
+
+	Sites  int `json:"sites"`
+	Rivers int `json:"rivers"`
+	Mines  int `json:"mines"`
+}
+
+func newMapRecord(p string, m *Map) MapRecord {
+	mr := MapRecord{
+		Path:   p,
+		Sites:  len(m.Sites),
+		Rivers: len(m.Rivers),
+		Mines:  len(m.Mines),
+	}
+
+	if b, err := json.Marshal(m); err == nil {
+		mr.SHA256 = fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+
+	return mr
+}
+
+// PunterRecord describes one punter's participation in a game.
+type PunterRecord struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+
+	// FutureErrors explains each future that was ignored.
+	FutureErrors []string `json:"future_errors,omitempty"`
+
+	Timeouts int  `json:"timeouts"`
+	Zombie   bool `json:"zombie"`
+}
+
+// MoveRecord describes a single turn.
+type MoveRecord struct {
+	Turn   int    `json:"turn"`
+	Punter uint64 `json:"punter"`
+
+	// Time is when the move was requested.
+	Time time.Time `json:"time"`
+
+	// Seconds is the wall-clock time the turn took, including
+	// communication.
+	Seconds float64 `json:"seconds"`
+
+	// Move is the move actually made.
+	Move Move `json:"move"`
+
+	// Rejection explains why the punter's move was replaced with a pass.
+	Rejection *engine.MoveError `json:"rejection,omitempty"`
+
+	// TimedOut and Zombie explain a pass that the punter didn't send.
+	TimedOut bool `json:"timed_out,omitempty"`
+	Zombie   bool `json:"zombie,omitempty"`
+}
+
+// How a game ended.
+const (
+	StatusComplete = "complete"
+
+	// StatusAbandoned games ended early because every punter had become
+	// a zombie.
+	StatusAbandoned = "abandoned"
+
+	// StatusFailed games were cut short by an error.
+	StatusFailed = "failed"
+)
+
+// GameRecord is a self-contained record of a game, complete or not.
+type GameRecord struct {
+	Map      MapRecord `json:"map"`
+	Port     int       `json:"port"`
+	Settings Settings  `json:"settings"`
+
+	Status string `json:"status"`
+
+	// Error is why a failed game was cut short.
+	Error string `json:"error,omitempty"`
+
+	SetupTimeout float64 `json:"setup_timeout"`
+	MoveTimeout  float64 `json:"move_timeout"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Punters []PunterRecord  `json:"punters"`
+	Moves   []MoveRecord    `json:"moves"`
+	Futures []PunterFutures `json:"futures"`
+	Scores  []Score         `json:"scores"`
+}
+
+// Results writes the record of each game to its own file in Dir.
+//
+// It is safe for concurrent use.
+type Results struct {
+	Dir string
+}
+
+// Record writes rec.
+func (r *Results) Record(rec *GameRecord) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal game record: %v", err)
+	}
+
+	// Games that failed before every seat was taken never started.
+	start := rec.Start
+	if start.IsZero() {
+		start = rec.End
+	}
+
+	name := fmt.Sprintf("%s.%d.%d.json", path.Base(rec.Map.Path), rec.Port, start.UnixNano())
+	return ioutil.WriteFile(filepath.Join(r.Dir, name), b, 0644)
+}
@@ -1,24 +1,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"time"
 
 	"bufio"
 
-	"github.com/jemoster/icfp2017/src/graph"
+	"github.com/jemoster/icfp2017/src/engine"
 
 	. "github.com/jemoster/icfp2017/src/protocol"
 	. "github.com/jemoster/icfp2017/src/protocol/io"
 )
 
-type Future struct {
-	Source SiteID `json:"source"`
-	Target SiteID `json:"target"`
-}
-
 type Punter struct {
 	ID   uint64
 	Name string
@@ -28,11 +24,33 @@ type Punter struct {
 	reader *bufio.Reader
 	writer io.Writer
 
-	futures  map[SiteID]SiteID
-	splurges int
-	options  int
+	// timeouts is the number of times this punter has failed to respond
+	// before the deadline.
+	timeouts int
+
+	// late is the number of responses that missed their deadline and have
+	// not yet been read. They are discarded when they arrive.
+	late int
+
+	// zombie punters have disconnected or timed out too many times. They
+	// are sent nothing further and pass for the rest of the game.
+	zombie bool
 }
 
+// acceptError is returned by Session.play when the listener fails, rather
+// than the game.
+type acceptError struct {
+	err error
+}
+
+func (e acceptError) Error() string {
+	return fmt.Sprintf("accept failed: %v", e.err)
+}
+
+// errTimeout is returned by Session.recv when a punter does not respond
+// before the deadline.
+var errTimeout = errors.New("timed out")
+
 type recvHandshake struct {
 	Name string `json:"me"`
 }
@@ -48,14 +66,9 @@ type sendSetup struct {
 	Settings Settings `json:"settings"`
 }
 
-type recvSetup struct {
-	Ready   uint64   `json:"ready"`
-	Futures []Future `json:"futures"`
-}
-
 type sendMove struct {
 	Move struct {
-		Moves []*Move `json:"moves"`
+		Moves []Move `json:"moves"`
 	} `json:"move"`
 }
 
@@ -63,333 +76,484 @@ type recvMove struct {
 	Move
 }
 
-type stop struct {
-	Moves  []*Move `json:"moves"`
-	Scores []Score `json:"scores"`
-}
-
 type sendStop struct {
-	Stop stop `json:"stop"`
+	Stop Stop `json:"stop"`
 }
 
 type Session struct {
 	Map      Map
+	MapPath  string
 	Settings Settings
 
+	// Port is the port the session is hosted on, used to identify it in
+	// logs.
+	Port int
+
 	Punters    []Punter
 	NumPunters int
 
-	Graph *graph.Graph
-}
+	// SetupTimeout bounds the handshake and setup responses.
+	SetupTimeout time.Duration
 
-func (s *Session) acceptMove(punter *Punter) (*Move, error) {
-	var rM recvMove
-	if err := Recv(punter.reader, &rM); err != nil {
-		return nil, err
-	}
+	// MoveTimeout bounds each move response.
+	MoveTimeout time.Duration
 
-Outer:
-	switch {
-	case rM.Move.Claim != nil:
-		claim := rM.Move.Claim
+	// MaxTimeouts is the number of timeouts after which a punter becomes a
+	// zombie. Zero means punters are never zombied for timing out.
+	MaxTimeouts int
 
-		ok := s.Graph.HasEdgeBetween(claim.Source, claim.Target)
+	Game *engine.GameState
 
-		if !ok {
-			fmt.Printf("[%d] claimed a river that doesn't exist! D:", punter.ID)
-			break
-		}
+	// Record is the record of the game so far.
+	Record *GameRecord
 
-		river := s.Graph.EdgeBetween(claim.Source, claim.Target).(*graph.MetadataEdge)
+	// Lobby, if not nil, is kept up to date with the session's progress.
+	Lobby *Lobby
+}
 
-		if river.IsOwned {
-			fmt.Printf("[%d] claimed a river that has already been claimed! D:\n", punter.ID)
-			break
-		}
+// logf logs a message prefixed with the session's port.
+func (s *Session) logf(format string, v ...interface{}) {
+	fmt.Printf("[:%d] "+format, append([]interface{}{s.Port}, v...)...)
+}
 
-		river.IsOwned = true
-		river.OwnerPunter = punter.ID
+// send sends v to punter, waiting at most timeout.
+func (s *Session) send(punter *Punter, v interface{}, timeout time.Duration) error {
+	if err := punter.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer punter.conn.SetWriteDeadline(time.Time{})
 
-		return &Move{Claim: claim}, nil
+	return Send(punter.writer, v)
+}
 
-	case rM.Move.Splurge != nil:
-		if !s.Settings.Splurges {
-			fmt.Printf("[%d] tried to splurge, but splurging is disabled.\n", punter.ID)
-			break
+// recv receives a message from punter into v, waiting at most timeout.
+//
+// errTimeout is returned if the punter did not begin its response before
+// the deadline; the connection is still usable. Any other error means the
+// connection is broken.
+func (s *Session) recv(punter *Punter, v interface{}, timeout time.Duration) error {
+	if err := punter.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer punter.conn.SetReadDeadline(time.Time{})
+
+	// Peek does not consume anything, so timing out while waiting for the
+	// first byte leaves the stream intact for the late response.
+	waitForResponse := func() error {
+		if _, err := punter.reader.Peek(1); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return errTimeout
+			}
+			return err
 		}
+		return nil
+	}
 
-		splurge := rM.Move.Splurge
-
-		if len(splurge.Route) < 2 {
-			fmt.Printf("[%d] tried to splurge, but did not specify enough sites.\n", punter.ID)
-			break
+	for punter.late > 0 {
+		if err := waitForResponse(); err != nil {
+			return err
+		}
+		if _, err := ReadMessage(punter.reader); err != nil {
+			return err
 		}
+		punter.late--
+		s.logf("[%d] discarded a late response.\n", punter.ID)
+	}
 
-		if len(splurge.Route) > punter.splurges+1 {
-			fmt.Printf("[%d] tried to splurge, but does not have enough lethargy (needs: %d, has: %d).\n", punter.ID, len(splurge.Route)-1, punter.splurges)
-			break
+	if err := waitForResponse(); err != nil {
+		if err == errTimeout {
+			punter.late++
 		}
+		return err
+	}
 
-		optionsNeeded := 0
+	return Recv(punter.reader, v)
+}
 
-		src := splurge.Route[0]
-		for _, tgt := range splurge.Route[1:] {
-			edge := s.Graph.EdgeBetween(src, tgt).(*graph.MetadataEdge) // should make sure it exists first..
+// timedOut notifies punter that it missed a deadline of d, zombifying it if
+// it has now timed out too many times.
+func (s *Session) timedOut(punter *Punter, d time.Duration) {
+	punter.timeouts++
 
-			if edge.IsOwned {
-				if edge.IsOptioned {
-					fmt.Printf("[%d] tried to splurge, but an edge on its path is owned and already optioned.\n", punter.ID)
-					break Outer
-				}
+	s.logf("[%d] timed out (%d times so far).\n", punter.ID, punter.timeouts)
 
-				if !s.Settings.Options {
-					fmt.Printf("[%d] tried to splurge, but an edge on its path is owned and optioneds is not enabled.\n", punter.ID)
-					break Outer
-				}
+	if err := s.send(punter, Timeout{Timeout: d.Seconds()}, d); err != nil {
+		s.zombify(punter, err)
+		return
+	}
 
-				optionsNeeded += 1
-			}
+	if s.MaxTimeouts > 0 && punter.timeouts >= s.MaxTimeouts {
+		s.zombify(punter, fmt.Errorf("timed out %d times", punter.timeouts))
+	}
+}
 
-			src = tgt
-		}
+// zombify marks punter as a zombie for the rest of the game.
+func (s *Session) zombify(punter *Punter, reason error) {
+	if punter.zombie {
+		return
+	}
 
-		if optionsNeeded > punter.options {
-			fmt.Printf("[%d] tried to splurge, but does not have enough options (needs: %d, have: %d).\n", punter.ID, optionsNeeded, punter.options)
-			break
-		}
+	s.logf("[%d] is now a zombie: %v\n", punter.ID, reason)
 
-		src = splurge.Route[0]
-		for _, tgt := range splurge.Route[1:] {
-			edge := s.Graph.EdgeBetween(src, tgt).(*graph.MetadataEdge) // should make sure it exists first..
+	punter.zombie = true
+	punter.conn.Close()
+}
 
-			if edge.IsOwned {
-				edge.OptionPunter = punter.ID
-				punter.options--
-			} else {
-				edge.IsOwned = true
-				edge.OwnerPunter = punter.ID
-			}
+// failed handles an error communicating with punter, using d as the deadline
+// to report for timeouts.
+func (s *Session) failed(punter *Punter, err error, d time.Duration) {
+	if err == errTimeout {
+		s.timedOut(punter, d)
+		return
+	}
+	s.zombify(punter, err)
+}
 
-			src = tgt
-		}
+// acceptMove receives a move from punter and applies it, substituting a pass
+// if the move is illegal. The reason for substituting a pass is returned.
+func (s *Session) acceptMove(punter *Punter) (*engine.MoveError, error) {
+	var rM recvMove
+	if err := s.recv(punter, &rM, s.MoveTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := s.Game.ApplyMove(punter.ID, rM.Move); err != nil {
+		s.logf("[%d] %v.\n", punter.ID, err)
+		return err.(*engine.MoveError), s.Game.Pass(punter.ID)
+	}
 
-		punter.splurges -= len(splurge.Route)
+	return nil, nil
+}
 
-		return &rM.Move, nil
-	case rM.Move.Option != nil:
-		if !s.Settings.Options {
-			fmt.Printf("[%d] tried to option, but options is disabled.\n", punter.ID)
-			break
-		}
+// turn plays a single turn for the current punter, returning its record.
+func (s *Session) turn() MoveRecord {
+	punter := &s.Punters[s.Game.Current()]
 
-		option := rM.Move.Option
+	rec := MoveRecord{
+		Turn:   s.Game.Turn,
+		Punter: punter.ID,
+		Time:   time.Now(),
+	}
 
-		if punter.options < 1 {
-			fmt.Printf("[%d] tried to option, but has no options remaining.\n", punter.ID)
+	switch {
+	case punter.zombie:
+		s.Game.Pass(punter.ID)
+	default:
+		sM := new(sendMove)
+		sM.Move.Moves = s.Game.LastMoves()
+
+		if err := s.send(punter, sM, s.MoveTimeout); err != nil {
+			s.zombify(punter, err)
+			s.Game.Pass(punter.ID)
 			break
 		}
 
-		edge := s.Graph.EdgeBetween(option.Source, option.Target).(*graph.MetadataEdge)
-
-		if !edge.IsOwned {
-			fmt.Printf("[%d] tried to option, but it isn't owned.  I don't know what to do here, so I'm going to just go with it.\n", punter.ID)
+		rejection, err := s.acceptMove(punter)
+		if err != nil {
+			rec.TimedOut = err == errTimeout
+			s.failed(punter, err, s.MoveTimeout)
+			s.Game.Pass(punter.ID)
 		}
+		rec.Rejection = rejection
+	}
 
-		if edge.IsOptioned {
-			fmt.Printf("[%d] tried to option, but the option has already been assigned.\n", punter.ID)
-		}
+	rec.Zombie = punter.zombie
+	rec.Seconds = time.Since(rec.Time).Seconds()
+	rec.Move = s.Game.History[len(s.Game.History)-1]
 
-		punter.options--
-		edge.IsOptioned = true
-		edge.OptionPunter = punter.ID
+	return rec
+}
 
-		return &rM.Move, nil
+// handshake performs the handshake with a newly connected punter.
+func (s *Session) handshake(punter *Punter) {
+	var rH recvHandshake
+	if err := s.recv(punter, &rH, s.SetupTimeout); err != nil {
+		// Without a handshake the stream can't be trusted.
+		s.zombify(punter, fmt.Errorf("handshake failed: %v", err))
+		return
 	}
 
-	// If it is nothing else, then assume Pass.
-	punter.splurges++
+	punter.Name = rH.Name
+
+	s.logf("Welcome, %s!\n", rH.Name)
 
-	return &Move{Pass: &Pass{punter.ID}}, nil
+	if err := s.send(punter, sendHandshake{rH.Name}, s.SetupTimeout); err != nil {
+		s.zombify(punter, err)
+	}
 }
 
 func (s *Session) play(srv net.Listener) ([]Score, error) {
-	s.Graph = graph.New(&s.Map, func(e *graph.MetadataEdge) float64 { return 1.0 })
+	s.Game = engine.New(&s.Map, s.NumPunters, s.Settings)
+
+	s.Record = &GameRecord{
+		Map:          newMapRecord(s.MapPath, &s.Map),
+		Port:         s.Port,
+		Settings:     s.Settings,
+		SetupTimeout: s.SetupTimeout.Seconds(),
+		MoveTimeout:  s.MoveTimeout.Seconds(),
+		Punters:      make([]PunterRecord, s.NumPunters),
+	}
+	for i := range s.Record.Punters {
+		s.Record.Punters[i].ID = uint64(i)
+	}
 
 	s.Punters = make([]Punter, s.NumPunters)
 
-	fmt.Printf("-\n")
-	fmt.Printf("Waiting on clients...\n")
+	s.Lobby.open(s)
 
+	s.logf("-\n")
+	s.logf("Waiting on clients...\n")
+
+	// Punters handshake as they arrive, so the lobby can show who is
+	// seated while waiting for the rest.
 	for i := 0; i < s.NumPunters; i++ {
 		conn, err := srv.Accept()
 		if err != nil {
-			return nil, err
+			return nil, acceptError{err}
 		}
 		defer conn.Close()
 
-		s.Punters[i].ID = uint64(i)
-		s.Punters[i].conn = conn
-		s.Punters[i].reader = bufio.NewReader(conn)
-		s.Punters[i].writer = conn
-		s.Punters[i].splurges = 0
-		s.Punters[i].options = len(s.Map.Mines)
-
-		fmt.Printf("  [%d/%d] Client connected.\n", i+1, s.NumPunters)
-	}
-
-	for i := 0; i < s.NumPunters; i++ {
 		punter := &s.Punters[i]
+		punter.ID = uint64(i)
+		punter.conn = conn
+		punter.reader = bufio.NewReader(conn)
+		punter.writer = conn
 
-		var rH recvHandshake
-		if err := Recv(punter.reader, &rH); err != nil {
-			return nil, err
-		}
+		s.logf("  [%d/%d] Client connected.\n", i+1, s.NumPunters)
 
-		punter.Name = rH.Name
+		s.handshake(punter)
+		s.Lobby.seat(s.Port, punter.Name)
+	}
 
-		fmt.Printf("Welcome, %s!\n", rH.Name)
+	s.Record.Start = time.Now()
+	s.Lobby.setState(s.Port, stateInProgress)
 
-		if err := Send(punter.writer, sendHandshake{rH.Name}); err != nil {
-			return nil, err
-		}
+	names := make([]string, s.NumPunters)
+	for i := range s.Punters {
+		names[i] = s.Punters[i].Name
 	}
+	s.Lobby.publish(s.Port, "start", startEvent{names})
 
 	for i := 0; i < s.NumPunters; i++ {
 		punter := &s.Punters[i]
+		if punter.zombie {
+			continue
+		}
 
 		setup := sendSetup{
 			uint64(i), uint64(s.NumPunters), &s.Map, s.Settings,
 		}
 
-		if err := Send(punter.writer, setup); err != nil {
-			return nil, err
+		if err := s.send(punter, setup, s.SetupTimeout); err != nil {
+			s.zombify(punter, err)
+			continue
 		}
 
-		var rS recvSetup
-		if err := Recv(punter.reader, &rS); err != nil {
-			return nil, err
+		var rS Ready
+		if err := s.recv(punter, &rS, s.SetupTimeout); err != nil {
+			s.failed(punter, err, s.SetupTimeout)
+			continue
 		}
 
 		if rS.Ready != uint64(i) {
-			log.Printf("[WARNING] Punter %d is very confused about it's identity.")
+			s.logf("[WARNING] Punter %d is very confused about it's identity.\n", i)
 		}
 
-		for _, future := range rS.Futures {
-			punter.futures[future.Source] = future.Target // todo: ensure source is mine
+		for _, err := range s.Game.SetFutures(punter.ID, rS.Futures) {
+			s.logf("[%d] %v.\n", punter.ID, err)
+			s.Record.Punters[i].FutureErrors = append(s.Record.Punters[i].FutureErrors, err.Error())
 		}
 	}
 
-	sM := new(sendMove)
-	sM.Move.Moves = make([]*Move, s.NumPunters)
+	for !s.Game.Done() {
+		if s.abandoned() {
+			s.logf("Every punter is a zombie; abandoning the game.\n")
+			s.Record.Status = StatusAbandoned
+			break
+		}
 
-	for i, _ := range s.Punters {
-		sM.Move.Moves[i] = &Move{Pass: &Pass{uint64(i)}}
+		rec := s.turn()
+		s.Record.Moves = append(s.Record.Moves, rec)
+
+		s.Lobby.setTurn(s.Port, s.Game.Turn)
+		s.Lobby.publish(s.Port, "move", rec)
+		s.Lobby.publish(s.Port, "scores", scoresEvent{s.Game.Turn, s.Game.Scores()})
 	}
 
-	for curTurn := 0; curTurn < len(s.Map.Rivers); curTurn++ {
-		punter := &s.Punters[curTurn%s.NumPunters]
+	sv := s.Game.Scores()
 
-		if err := Send(punter.writer, sM); err != nil {
-			return nil, err
-		}
+	sS := sendStop{
+		Stop{
+			Moves:   s.Game.LastMoves(),
+			Scores:  sv,
+			Futures: s.Game.Futures(),
+		},
+	}
 
-		move, err := s.acceptMove(punter)
-		if err != nil {
-			return nil, err
+	for i := 0; i < s.NumPunters; i++ {
+		punter := &s.Punters[i]
+		if punter.zombie {
+			continue
 		}
 
-		sM.Move.Moves[punter.ID] = move
-
+		if err := s.send(punter, sS, s.MoveTimeout); err != nil {
+			s.logf("[%d] failed to receive stop: %v\n", punter.ID, err)
+		}
 	}
 
-	dist := s.Graph.ShortestDistances(s.Map.Mines)
-
-	sv := s.Graph.Score(s.Map.Mines, s.NumPunters, func(p uint64, src, dst SiteID) int64 {
-		d := int64(dist[src][dst])
-		v := d * d
+	s.Record.Futures = sS.Stop.Futures
+	s.Record.Scores = sv
+	s.finishRecord(nil)
 
-		if s.Settings.Futures {
-			if s.Punters[p].futures[src] == dst {
-				v += 2 * d * d * d // add double because so we can remove indiscriminately later
-			}
-		}
+	s.Lobby.finish(s.Port, sv)
+	s.Lobby.publish(s.Port, "stop", sS.Stop)
 
-		return v
-	})
+	return sv, nil
+}
 
-	for i, _ := range sv {
-		score := &sv[i]
-		for src, dst := range s.Punters[score.Punter].futures {
-			d := int64(dist[src][dst])
-			score.Score -= d * d * d // we can remove always because we added double if present earlier
+// abandoned reports whether every punter is a zombie, so that the rest of
+// the game would be passes.
+func (s *Session) abandoned() bool {
+	for i := range s.Punters {
+		if !s.Punters[i].zombie {
+			return false
 		}
 	}
+	return true
+}
 
-	sS := sendStop{
-		stop{
-			Moves:  sM.Move.Moves,
-			Scores: sv,
-		},
-	}
+// seated reports whether any punter has connected.
+func (s *Session) seated() bool {
+	return len(s.Punters) > 0 && s.Punters[0].conn != nil
+}
 
-	for i := 0; i < s.NumPunters; i++ {
-		punter := &s.Punters[i]
+// finishRecord fills in how the game ended, and with err, why it was cut
+// short.
+func (s *Session) finishRecord(err error) {
+	rec := s.Record
+	rec.End = time.Now()
 
-		if err := Send(punter.writer, sS); err != nil {
-			return nil, err
-		}
+	switch {
+	case err != nil:
+		rec.Status = StatusFailed
+		rec.Error = err.Error()
+	case rec.Status == "":
+		rec.Status = StatusComplete
 	}
 
-	return sv, nil
+	for i := range s.Punters {
+		punter := &s.Punters[i]
+		rec.Punters[i].Name = punter.Name
+		rec.Punters[i].Timeouts = punter.timeouts
+		rec.Punters[i].Zombie = punter.zombie
+	}
 }
 
+// Server hosts consecutive sessions on a single port.
 type Server struct {
 	Map        Map
+	MapPath    string
 	Port       int
 	NumPunters int
 	Settings   Settings
 	RunOnce    bool
+
+	SetupTimeout time.Duration
+	MoveTimeout  time.Duration
+	MaxTimeouts  int
+
+	Lobby *Lobby
+}
+
+// logf logs a message prefixed with the server's port, so output from
+// concurrent servers can be told apart.
+func (s *Server) logf(format string, v ...interface{}) {
+	fmt.Printf("[:%d] "+format, append([]interface{}{s.Port}, v...)...)
 }
 
-func (s *Server) run(results *bufio.Writer) {
+// playSession plays session on srv, converting a panic into an error so that
+// one broken game doesn't take down the other servers.
+func (s *Server) playSession(session *Session, srv net.Listener) (scores []Score, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("session panicked: %v", r)
+		}
+	}()
+
+	return session.play(srv)
+}
+
+// run hosts sessions until RunOnce is satisfied, recording each outcome in
+// results. A failed game is logged and the next one hosted, but if the
+// listener fails for good, run returns its error.
+func (s *Server) run(results *Results) error {
 	laddr := fmt.Sprintf(":%d", s.Port)
 
 	srv, err := net.Listen("tcp", laddr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer srv.Close()
 
-	fmt.Printf("-\n")
-	fmt.Printf("Listening at %s\n", laddr)
+	s.logf("-\n")
+	s.logf("Listening at %s\n", laddr)
+
+	// delay is how long to wait after a temporary failure to accept a
+	// connection, as in net/http.
+	var delay time.Duration
 
 	for {
 		session := Session{
-			Map:        s.Map,
-			NumPunters: s.NumPunters,
-			Settings:   s.Settings,
+			Map:          s.Map,
+			MapPath:      s.MapPath,
+			Port:         s.Port,
+			NumPunters:   s.NumPunters,
+			Settings:     s.Settings,
+			SetupTimeout: s.SetupTimeout,
+			MoveTimeout:  s.MoveTimeout,
+			MaxTimeouts:  s.MaxTimeouts,
+			Lobby:        s.Lobby,
 		}
 
-		scores, err := session.play(srv)
-		if err != nil {
-			fmt.Printf("[ERROR] %+v\n", err)
-			continue
+		scores, err := s.playSession(&session, srv)
+
+		// Keep a record of every game that got as far as a punter
+		// connecting, however it ended.
+		if err != nil && session.seated() {
+			session.finishRecord(err)
+		}
+		if session.Record != nil && session.Record.Status != "" {
+			if err := results.Record(session.Record); err != nil {
+				s.logf("[ERROR] failed to record results: %v\n", err)
+			}
 		}
 
-		fmt.Printf("Score: %+v\n", scores)
+		if ae, ok := err.(acceptError); ok {
+			// Only retry if the listener may recover.
+			if ne, ok := ae.err.(net.Error); !ok || !ne.Temporary() {
+				return err
+			}
 
-		results.WriteString(fmt.Sprintf("%d\n", session.NumPunters))
-		for _, score := range scores {
-			id := score.Punter
-			name := session.Punters[id].Name
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			s.logf("[ERROR] %v; retrying in %v\n", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
 
-			results.WriteString(fmt.Sprintf("%s\n", name))
-			results.WriteString(fmt.Sprintf("%d\n", score.Score))
+		if err != nil {
+			s.logf("[ERROR] %+v\n", err)
+			continue
 		}
-		results.Flush()
+
+		s.logf("Score: %+v\n", scores)
 
 		if s.RunOnce {
-			return
+			return nil
 		}
 	}
 }
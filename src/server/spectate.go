@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	. "github.com/jemoster/icfp2017/src/protocol"
+)
+
+// Spectators follow a game live as server-sent events from
+// /events/<port>. Each event's data is a JSON object:
+//
+//	setup   {"punters": n, "map": Map, "settings": Settings}
+//	start   {"names": [...]}, once every seat is taken
+//	move    a MoveRecord, whose "move" is a protocol.Move
+//	scores  {"turn": n, "scores": [Score...]}, after every move
+//	stop    a protocol.Stop
+//
+// A spectator that connects mid-game is first sent every event of the game
+// so far, except that of the scores only the latest are sent, as each
+// supersedes the last.
+
+type setupEvent struct {
+	Punters  int      `json:"punters"`
+	Map      *Map     `json:"map"`
+	Settings Settings `json:"settings"`
+}
+
+type startEvent struct {
+	Names []string `json:"names"`
+}
+
+type scoresEvent struct {
+	Turn   int     `json:"turn"`
+	Scores []Score `json:"scores"`
+}
+
+// spectatorBuffer is the number of events a spectator may fall behind by
+// before it is disconnected.
+const spectatorBuffer = 256
+
+// stream is the event stream of the sessions on one port.
+type stream struct {
+	// history holds the events of the current game but scores, which
+	// are only kept in latest. It grows with the moves made, so no larger
+	// than the map.
+	history [][]byte
+	latest  []byte
+
+	spectators map[chan []byte]bool
+}
+
+func (l *Lobby) stream(port int) *stream {
+	st, ok := l.streams[port]
+	if !ok {
+		st = &stream{spectators: make(map[chan []byte]bool)}
+		l.streams[port] = st
+	}
+	return st
+}
+
+// publish sends an event to every spectator of the session on port. An
+// event named "setup" begins a new game.
+func (l *Lobby) publish(port int, event string, v interface{}) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	msg := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stream(port)
+	switch event {
+	case "setup":
+		st.history, st.latest = [][]byte{msg}, nil
+	case "scores":
+		st.latest = msg
+	default:
+		st.history = append(st.history, msg)
+	}
+
+	for c := range st.spectators {
+		select {
+		case c <- msg:
+		default:
+			// Too slow to keep up; it can reconnect and catch up.
+			delete(st.spectators, c)
+			close(c)
+		}
+	}
+}
+
+// subscribe returns a channel of events for the session on port, beginning
+// with the events of the game so far.
+func (l *Lobby) subscribe(port int) chan []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stream(port)
+	c := make(chan []byte, len(st.history)+1+spectatorBuffer)
+	for _, msg := range st.history {
+		c <- msg
+	}
+	if st.latest != nil {
+		c <- st.latest
+	}
+	st.spectators[c] = true
+	return c
+}
+
+func (l *Lobby) unsubscribe(port int, c chan []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.stream(port)
+	if st.spectators[c] {
+		delete(st.spectators, c)
+		close(c)
+	}
+}
+
+// serveEvents streams the events of the session on the port named in the
+// request path.
+func (l *Lobby) serveEvents(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/events/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	l.mu.Lock()
+	_, exists := l.sessions[port]
+	l.mu.Unlock()
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// The viewer is usually served from elsewhere.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	c := l.subscribe(port)
+	defer l.unsubscribe(port, c)
+
+	closed := r.Context().Done()
+	for {
+		select {
+		case msg, ok := <-c:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-closed:
+			return
+		}
+	}
+}
@@ -1,18 +1,21 @@
 #!/usr/bin/env python3
+import argparse
 import urllib.request
 import json
 
+PUNTER_STATUS = 'http://punter.inf.ed.ac.uk/status.json'
+
 
 def waiting_for(game):
     return game['total_punters'] - len(game['punters'])
 
 
-def read_status(retries=10):
-    PUNTER_STATUS = 'http://punter.inf.ed.ac.uk/status.json'
-
+def read_status(retries=10, url=PUNTER_STATUS):
+    """Read the lobby of the official server, or of src/server at e.g.
+    http://localhost:8000/status.json"""
     for retry in range(retries):
         try:
-            with urllib.request.urlopen(PUNTER_STATUS) as response:
+            with urllib.request.urlopen(url) as response:
                html = response.read()
             game_list = json.loads(html.decode())
         except Exception as e:
@@ -26,5 +29,9 @@ def read_status(retries=10):
 
 
 if __name__ == '__main__':
-    openings = [k for k, v in read_status().items() if waiting_for(v) > 0]
+    parser = argparse.ArgumentParser(description='List games waiting for punters')
+    parser.add_argument('--url', action='store', default=PUNTER_STATUS, help='status.json of the server to query')
+    results = parser.parse_args()
+
+    openings = [k for k, v in read_status(url=results.url).items() if waiting_for(v) > 0]
     print(openings)